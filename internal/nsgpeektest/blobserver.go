@@ -0,0 +1,360 @@
+package nsgpeektest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlobServer is an in-process fake of the parts of the Azure Blob REST API that nsgpeek uses: listing
+// a container, getting a blob's block list, downloading a range of a blob and uploading a blob block by
+// block.  Accounts are addressed path-style, as they are in Azurite, e.g.
+// http://127.0.0.1:1234/account/container/blob.  Requests aren't authenticated so clients should be
+// created with a SAS URL from SasUrl.
+type BlobServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	blobs map[string]*serverBlob
+	// staged holds the uncommitted blocks uploaded for each blob by id
+	staged map[string]map[string][]byte
+	// blockListRequests counts the block list requests made for each blob
+	blockListRequests map[string]int
+}
+
+type serverBlob struct {
+	blocks       [][]byte
+	lastModified time.Time
+}
+
+func (b *serverBlob) data() []byte {
+	var data []byte
+	for _, bl := range b.blocks {
+		data = append(data, bl...)
+	}
+	return data
+}
+
+func NewBlobServer() *BlobServer {
+	s := &BlobServer{
+		blobs:             make(map[string]*serverBlob),
+		staged:            make(map[string]map[string][]byte),
+		blockListRequests: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// BlobEndpoint returns the endpoint to use for a custom cloud whose storage accounts are in the server.
+func (s *BlobServer) BlobEndpoint() string {
+	return s.URL + "/"
+}
+
+// SasUrl returns a SAS URL for the blob service of an account in the server.
+func (s *BlobServer) SasUrl(account string) string {
+	return fmt.Sprintf("%v/%v?sv=2020-10-02&sig=fake", s.URL, account)
+}
+
+// ContainerSasUrl returns a SAS URL for a container in the server.
+func (s *BlobServer) ContainerSasUrl(account string, container string) string {
+	return fmt.Sprintf("%v/%v/%v?sv=2020-10-02&sig=fake", s.URL, account, container)
+}
+
+// BlobNames returns the names of the blobs in a container, in order.
+func (s *BlobServer) BlobNames(account string, container string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for k := range s.blobs {
+		if name := strings.TrimPrefix(k, blobKey(account, container, "")); name != k {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// PutBlob creates or replaces a block blob made up of the given blocks.
+func (s *BlobServer) PutBlob(account string, container string, name string, blocks ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[blobKey(account, container, name)] = &serverBlob{blocks: blocks, lastModified: time.Now().UTC()}
+}
+
+// AppendBlocks commits blocks to a blob just before its final block, the way flow log records are added
+// to a PT1H.json blob ahead of the closing brackets.
+func (s *BlobServer) AppendBlocks(account string, container string, name string, blocks ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.blobs[blobKey(account, container, name)]
+	if !ok || len(b.blocks) == 0 {
+		panic(fmt.Sprintf("no blob %v to append to", name))
+	}
+
+	last := b.blocks[len(b.blocks)-1]
+	b.blocks = append(append(b.blocks[:len(b.blocks)-1:len(b.blocks)-1], blocks...), last)
+	b.lastModified = time.Now().UTC()
+}
+
+// BlockListRequests returns the number of times a blob's block list has been requested.
+func (s *BlobServer) BlockListRequests(account string, container string, name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blockListRequests[blobKey(account, container, name)]
+}
+
+func blobKey(account string, container string, name string) string {
+	return account + "/" + container + "/" + name
+}
+
+func (s *BlobServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	q := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPut && len(parts) == 3 && q.Get("comp") == "block":
+		s.stageBlock(w, r, blobKey(parts[0], parts[1], parts[2]), q.Get("blockid"))
+	case r.Method == http.MethodPut && len(parts) == 3 && q.Get("comp") == "blocklist":
+		s.commitBlockList(w, r, blobKey(parts[0], parts[1], parts[2]))
+	case r.Method != http.MethodGet:
+		writeStorageError(w, http.StatusMethodNotAllowed, "UnsupportedHttpVerb")
+	case len(parts) == 2 && q.Get("restype") == "container" && q.Get("comp") == "list":
+		s.listBlobs(w, parts[0], parts[1], q.Get("prefix"), q.Get("delimiter"))
+	case len(parts) == 3 && q.Get("comp") == "blocklist":
+		s.getBlockList(w, blobKey(parts[0], parts[1], parts[2]))
+	case len(parts) == 3 && q.Get("comp") == "":
+		s.download(w, r, blobKey(parts[0], parts[1], parts[2]))
+	default:
+		writeStorageError(w, http.StatusBadRequest, "UnsupportedQueryParameter")
+	}
+}
+
+type enumerationResults struct {
+	XMLName       xml.Name       `xml:"EnumerationResults"`
+	ContainerName string         `xml:"ContainerName,attr"`
+	Prefix        string         `xml:"Prefix"`
+	Delimiter     string         `xml:"Delimiter,omitempty"`
+	Blobs         []listedBlob   `xml:"Blobs>Blob"`
+	BlobPrefixes  []listedPrefix `xml:"Blobs>BlobPrefix"`
+	NextMarker    string         `xml:"NextMarker"`
+}
+
+type listedBlob struct {
+	Name       string          `xml:"Name"`
+	Properties listedBlobProps `xml:"Properties"`
+}
+
+type listedBlobProps struct {
+	LastModified  string `xml:"Last-Modified"`
+	ContentLength int    `xml:"Content-Length"`
+	BlobType      string `xml:"BlobType"`
+}
+
+type listedPrefix struct {
+	Name string `xml:"Name"`
+}
+
+func (s *BlobServer) listBlobs(w http.ResponseWriter, account string, container string, prefix string, delimiter string) {
+	res := enumerationResults{ContainerName: container, Prefix: prefix, Delimiter: delimiter}
+	containerKey := blobKey(account, container, "")
+	seenPrefixes := make(map[string]bool)
+
+	var keys []string
+	for k := range s.blobs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !strings.HasPrefix(k, containerKey+prefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(k, containerKey)
+
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				p := name[:len(prefix)+i+len(delimiter)]
+				if !seenPrefixes[p] {
+					seenPrefixes[p] = true
+					res.BlobPrefixes = append(res.BlobPrefixes, listedPrefix{p})
+				}
+				continue
+			}
+		}
+
+		b := s.blobs[k]
+		res.Blobs = append(res.Blobs, listedBlob{name, listedBlobProps{b.lastModified.Format(http.TimeFormat), len(b.data()), "BlockBlob"}})
+	}
+
+	writeXml(w, http.StatusOK, res)
+}
+
+type blockList struct {
+	XMLName         xml.Name      `xml:"BlockList"`
+	CommittedBlocks []listedBlock `xml:"CommittedBlocks>Block"`
+}
+
+type listedBlock struct {
+	Name string `xml:"Name"`
+	Size int    `xml:"Size"`
+}
+
+func (s *BlobServer) getBlockList(w http.ResponseWriter, key string) {
+	s.blockListRequests[key]++
+
+	b, ok := s.blobs[key]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+
+	var res blockList
+	for i, bl := range b.blocks {
+		id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%06d", i)))
+		res.CommittedBlocks = append(res.CommittedBlocks, listedBlock{id, len(bl)})
+	}
+
+	writeXml(w, http.StatusOK, res)
+}
+
+func (s *BlobServer) stageBlock(w http.ResponseWriter, r *http.Request, key string, id string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil || id == "" {
+		writeStorageError(w, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+
+	if s.staged[key] == nil {
+		s.staged[key] = make(map[string][]byte)
+	}
+	s.staged[key][id] = data
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+type blockLookupList struct {
+	XMLName     xml.Name `xml:"BlockList"`
+	Committed   []string `xml:"Committed"`
+	Uncommitted []string `xml:"Uncommitted"`
+	Latest      []string `xml:"Latest"`
+}
+
+// commitBlockList replaces a blob with the staged blocks it lists.  Blocks that are already committed
+// can't be reused.
+func (s *BlobServer) commitBlockList(w http.ResponseWriter, r *http.Request, key string) {
+	var list blockLookupList
+	if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+		writeStorageError(w, http.StatusBadRequest, "InvalidXmlDocument")
+		return
+	}
+
+	var blocks [][]byte
+	for _, id := range append(list.Uncommitted, list.Latest...) {
+		data, ok := s.staged[key][id]
+		if !ok {
+			writeStorageError(w, http.StatusBadRequest, "InvalidBlockList")
+			return
+		}
+		blocks = append(blocks, data)
+	}
+
+	s.blobs[key] = &serverBlob{blocks: blocks, lastModified: time.Now().UTC()}
+	delete(s.staged, key)
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *BlobServer) download(w http.ResponseWriter, r *http.Request, key string) {
+	b, ok := s.blobs[key]
+	if !ok {
+		writeStorageError(w, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+
+	data := b.data()
+	start, end := 0, len(data)-1
+	status := http.StatusOK
+
+	if rng := r.Header.Get("x-ms-range"); rng != "" {
+		var err error
+		if start, end, err = parseRange(rng, len(data)); err != nil {
+			writeStorageError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", start, end, len(data)))
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.Header().Set("Last-Modified", b.lastModified.Format(http.TimeFormat))
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	w.WriteHeader(status)
+	w.Write(data[start : end+1])
+}
+
+// parseRange parses a range header such as "bytes=0-99", where the end is optional.
+func parseRange(rng string, size int) (int, int, error) {
+	startStr, endStr, ok := strings.Cut(strings.TrimPrefix(rng, "bytes="), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %v", rng)
+	}
+
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.Atoi(endStr); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if end > size-1 {
+		end = size - 1
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("range %v is outside of the blob", rng)
+	}
+
+	return start, end, nil
+}
+
+type storageError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeStorageError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	writeXml(w, status, storageError{Code: code, Message: code})
+}
+
+func writeXml(w http.ResponseWriter, status int, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
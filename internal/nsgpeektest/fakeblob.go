@@ -1,7 +1,9 @@
 package nsgpeektest
 
 import (
+	"context"
 	"errors"
+	"sync"
 
 	"github.com/tmeadon/nsgpeek/pkg/azure"
 	"golang.org/x/exp/slices"
@@ -10,21 +12,29 @@ import (
 var FakeBlobData = "fake"
 var ErrGetBlockList error = errors.New("block list get error")
 
+// FakeBlob is safe to add blocks to while it is being read.
 type FakeBlob struct {
+	mu         sync.Mutex
 	Blocks     []azure.BlobBlock
 	BlocksRead []azure.BlobBlock
 }
 
-func (f *FakeBlob) ReadBlock(block *azure.BlobBlock, blockIndex int64) ([]byte, error) {
+func (f *FakeBlob) ReadBlock(ctx context.Context, block *azure.BlobBlock, blockIndex int64) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.BlocksRead = append(f.BlocksRead, *block)
 	return []byte(block.Name), nil
 }
 
-func (f *FakeBlob) GetBlocks() ([]azure.BlobBlock, error) {
-	return f.Blocks, nil
+func (f *FakeBlob) GetBlocks(ctx context.Context) ([]azure.BlobBlock, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.Blocks), nil
 }
 
 func (f *FakeBlob) AddBlocks(blocks []azure.BlobBlock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	f.Blocks = slices.Insert(f.Blocks, len(f.Blocks)-1, blocks...)
 }
 
@@ -38,11 +48,11 @@ type FakeErroringBlob struct {
 	blocks []azure.BlobBlock
 }
 
-func (f *FakeErroringBlob) ReadBlock(block *azure.BlobBlock, blockIndex int64) ([]byte, error) {
+func (f *FakeErroringBlob) ReadBlock(ctx context.Context, block *azure.BlobBlock, blockIndex int64) ([]byte, error) {
 	return []byte(block.Name), nil
 }
 
-func (f *FakeErroringBlob) GetBlocks() ([]azure.BlobBlock, error) {
+func (f *FakeErroringBlob) GetBlocks(ctx context.Context) ([]azure.BlobBlock, error) {
 	return f.blocks, ErrGetBlockList
 }
 
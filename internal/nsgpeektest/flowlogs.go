@@ -0,0 +1,75 @@
+package nsgpeektest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FlowLogContainer is the container NSG flow logs are written to.
+const FlowLogContainer = "insights-logs-networksecuritygroupflowevent"
+
+// FlowLogBlobPath returns the path of the PT1H.json blob that an NSG's flow logs for the hour containing
+// t are written to for the NIC with the given MAC address.
+func FlowLogBlobPath(nsgId string, t time.Time, mac string) string {
+	t = t.UTC()
+	return fmt.Sprintf("resourceId=%v/y=%04d/m=%02d/d=%02d/h=%02d/m=00/macAddress=%v/PT1H.json", strings.ToUpper(nsgId), t.Year(), t.Month(), t.Day(), t.Hour(), mac)
+}
+
+// FlowTuple returns a version 2 flow tuple for an allowed inbound TCP flow starting at t.
+func FlowTuple(t time.Time, srcAddr string, dstAddr string, srcPort int, dstPort int) string {
+	return fmt.Sprintf("%v,%v,%v,%v,%v,T,I,A,B,,,,", t.Unix(), srcAddr, dstAddr, srcPort, dstPort)
+}
+
+// FlowLogRecord returns a version 2 flow log record, as found in a PT1H.json blob, holding tuples.
+func FlowLogRecord(nsgId string, t time.Time, mac string, rule string, tuples ...string) []byte {
+	id := strings.ToUpper(nsgId)
+
+	record := map[string]interface{}{
+		"time":          t.UTC().Format(time.RFC3339Nano),
+		"systemId":      "e79aab03-ffb0-4419-8a28-90be262a7028",
+		"macAddress":    mac,
+		"category":      "NetworkSecurityGroupFlowEvent",
+		"resourceId":    id,
+		"operationName": "NetworkSecurityGroupFlowEvents",
+		"properties": map[string]interface{}{
+			"Version": 2,
+			"flows": []interface{}{
+				map[string]interface{}{
+					"rule": rule,
+					"flows": []interface{}{
+						map[string]interface{}{"mac": mac, "flowTuples": tuples},
+					},
+				},
+			},
+		},
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// FlowLogBlocks returns the blocks of a PT1H.json blob holding records, laid out the way Azure writes
+// them with an opening block, a block for each record and a closing block.
+func FlowLogBlocks(records ...[]byte) [][]byte {
+	blocks := [][]byte{[]byte(`{"records":[`)}
+
+	for i, r := range records {
+		if i == 0 {
+			blocks = append(blocks, r)
+		} else {
+			blocks = append(blocks, AppendedRecordBlock(r))
+		}
+	}
+
+	return append(blocks, []byte("]}"))
+}
+
+// AppendedRecordBlock returns the block for a record added after the first record in a blob.
+func AppendedRecordBlock(record []byte) []byte {
+	return append([]byte(","), record...)
+}
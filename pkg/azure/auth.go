@@ -2,96 +2,101 @@ package azure
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
+	"os"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 )
 
 type Credential struct {
 	azcore.TokenCredential
+	// Cloud is the cloud the credential authenticates to and whose endpoints are used with it.
+	Cloud Cloud
 }
 
-func GetCredential() (*Credential, error) {
-	cred, err := azidentity.NewAzureCLICredential(nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to obtain a credential: %w", err)
-	}
-	return &Credential{cred}, nil
-}
-
-func GetSubscriptions(cred *Credential) ([]string, error) {
-	t, err := getToken(cred)
-	if err != nil {
-		return nil, err
-	}
+// AuthMode selects how GetCredential authenticates to Azure.
+type AuthMode int
 
-	s, err := listSubscriptions(t)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
-	}
+const (
+	AuthAzureCli AuthMode = iota
+	AuthEnvironment
+	AuthManagedIdentity
+	AuthWorkloadIdentity
+	AuthDeviceCode
+	AuthDefault
+)
 
-	return s, nil
+type CredentialOptions struct {
+	Mode AuthMode
+	// TenantId optionally overrides the tenant to authenticate in.
+	TenantId string
+	// ClientId optionally selects a user-assigned managed identity or overrides the application used for
+	// workload identity and device code authentication.
+	ClientId string
+	// Cloud selects the cloud to authenticate to, defaulting to the Azure public cloud.
+	Cloud Cloud
 }
 
-func getToken(cred azcore.TokenCredential) (string, error) {
-	token, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{
-		Scopes: []string{"https://management.azure.com/"},
-	})
-
+// GetCredential returns a credential for the chosen mode.  Environment authentication uses a service
+// principal secret or certificate from the AZURE_* environment variables, the default mode tries
+// workload identity, environment, managed identity and then the Azure CLI in turn.
+func GetCredential(opts CredentialOptions) (*Credential, error) {
+	cred, err := newTokenCredential(opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to get token: %w", err)
+		return nil, fmt.Errorf("failed to obtain a credential: %w", err)
 	}
-
-	return token.Token, nil
+	return &Credential{cred, opts.Cloud}, nil
 }
 
-func listSubscriptions(token string) ([]string, error) {
-	client := &http.Client{}
-
-	req, err := http.NewRequest("GET", "https://management.azure.com/subscriptions?api-version=2022-01-01", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create http request: %w", err)
-	}
-
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to call subscriptions API: %w", err)
+func newTokenCredential(opts CredentialOptions) (azcore.TokenCredential, error) {
+	switch opts.Mode {
+	case AuthEnvironment:
+		return azidentity.NewEnvironmentCredential(&azidentity.EnvironmentCredentialOptions{ClientOptions: opts.Cloud.clientOptions()})
+
+	case AuthManagedIdentity:
+		miOpts := azidentity.ManagedIdentityCredentialOptions{ClientOptions: opts.Cloud.clientOptions()}
+		if opts.ClientId != "" {
+			miOpts.ID = azidentity.ClientID(opts.ClientId)
+		}
+		return azidentity.NewManagedIdentityCredential(&miOpts)
+
+	case AuthWorkloadIdentity:
+		return newWorkloadIdentityCredential(opts.TenantId, opts.ClientId, opts.Cloud)
+
+	case AuthDeviceCode:
+		return azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			ClientOptions: opts.Cloud.clientOptions(),
+			TenantID:      opts.TenantId,
+			ClientID:      opts.ClientId,
+			// keep the prompt out of stdout, which may be piped elsewhere
+			UserPrompt: func(ctx context.Context, m azidentity.DeviceCodeMessage) error {
+				fmt.Fprintln(os.Stderr, m.Message)
+				return nil
+			},
+		})
+
+	case AuthDefault:
+		return newDefaultCredential(opts)
 	}
 
-	defer res.Body.Close()
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
-	}
+	return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{TenantID: opts.TenantId})
+}
 
-	str := string(body)
-	_ = str
+// newDefaultCredential chains the SDK's default credential, which covers environment, managed identity
+// and Azure CLI authentication, behind workload identity when it is configured.
+func newDefaultCredential(opts CredentialOptions) (azcore.TokenCredential, error) {
+	var sources []azcore.TokenCredential
 
-	var subList subscriptionList
-	err = json.Unmarshal(body, &subList)
-	if err != nil {
-		return nil, fmt.Errorf("failed to deserialise subscription response: %w", err)
+	if wi, err := newWorkloadIdentityCredential(opts.TenantId, opts.ClientId, opts.Cloud); err == nil {
+		sources = append(sources, wi)
 	}
 
-	subs := make([]string, 0)
-
-	for _, s := range subList.Value {
-		subs = append(subs, s.SubscriptionId)
+	def, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: opts.Cloud.clientOptions(), TenantID: opts.TenantId})
+	if err != nil {
+		return nil, err
 	}
+	sources = append(sources, def)
 
-	return subs, nil
-}
-
-type subscriptionList struct {
-	Value []subscription `json:"value"`
-}
-
-type subscription struct {
-	SubscriptionId string `json:"subscriptionId"`
+	return azidentity.NewChainedTokenCredential(sources, nil)
 }
@@ -9,18 +9,66 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 )
 
+// BlockStore reads the blocks of a blob from wherever it is kept.
+type BlockStore interface {
+	GetBlocks(ctx context.Context) ([]BlobBlock, error)
+	ReadBlock(ctx context.Context, block *BlobBlock, blockIndex int64) ([]byte, error)
+	// URL identifies the blob.
+	URL() string
+}
+
 type Blob struct {
-	azblob.BlockBlobClient
+	Store        BlockStore
 	Path         string
 	LastModified time.Time
+	Size         int64
+	Retry        RetryPolicy
+}
+
+func (b *Blob) URL() string {
+	return b.Store.URL()
 }
 
-func (b *Blob) GetBlocks() ([]BlobBlock, error) {
-	blocks, err := b.GetBlockList(context.Background(), azblob.BlockListTypeAll, nil)
+func (b *Blob) GetBlocks(ctx context.Context) ([]BlobBlock, error) {
+	var blocks []BlobBlock
+
+	err := b.Retry.do(ctx, func() (err error) {
+		blocks, err = b.Store.GetBlocks(ctx)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get block list for blob %v: %w", b.Path, err)
 	}
 
+	return blocks, nil
+}
+
+func (b *Blob) ReadBlock(ctx context.Context, block *BlobBlock, blockIndex int64) ([]byte, error) {
+	var data []byte
+
+	err := b.Retry.do(ctx, func() (err error) {
+		data, err = b.Store.ReadBlock(ctx, block, blockIndex)
+		if err != nil {
+			return fmt.Errorf("failed to read block %v in blob %v: %w", block.Name, b.Path, err)
+		}
+		return nil
+	})
+
+	return data, err
+}
+
+// blockBlobStore reads blocks from a block blob in Azure storage.  Downloads interrupted part way
+// through aren't resumed as the whole block is retried by the blob's RetryPolicy.
+type blockBlobStore struct {
+	azblob.BlockBlobClient
+}
+
+func (s *blockBlobStore) GetBlocks(ctx context.Context) ([]BlobBlock, error) {
+	blocks, err := s.GetBlockList(ctx, azblob.BlockListTypeAll, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	bb := make([]BlobBlock, 0)
 
 	for _, b := range blocks.CommittedBlocks {
@@ -30,25 +78,25 @@ func (b *Blob) GetBlocks() ([]BlobBlock, error) {
 	return bb, nil
 }
 
-func (b *Blob) ReadBlock(block *BlobBlock, blockIndex int64) ([]byte, error) {
+func (s *blockBlobStore) ReadBlock(ctx context.Context, block *BlobBlock, blockIndex int64) ([]byte, error) {
 	downloadOpts := azblob.BlobDownloadOptions{Count: &block.Size, Offset: &blockIndex}
 
-	blockGet, err := b.Download(context.Background(), &downloadOpts)
+	blockGet, err := s.Download(ctx, &downloadOpts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download block %v in blob %v: %w", block.Name, b.Path, err)
+		return nil, fmt.Errorf("failed to download: %w", err)
 	}
 
 	data := &bytes.Buffer{}
-	reader := blockGet.Body(&azblob.RetryReaderOptions{})
+	reader := blockGet.Body(nil)
 
 	_, err = data.ReadFrom(reader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read block %v in blob %v: %w", block.Name, b.Path, err)
+		return nil, err
 	}
 
 	err = reader.Close()
 	if err != nil {
-		return nil, fmt.Errorf("failed to close reader for block %v in blob %v: %w", block.Name, b.Path, err)
+		return nil, fmt.Errorf("failed to close reader: %w", err)
 	}
 
 	return data.Bytes(), nil
@@ -0,0 +1,93 @@
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// accountPlaceholder is replaced with the storage account name in a cloud's blob endpoint.
+const accountPlaceholder = "{account}"
+
+// Cloud holds the endpoints used to talk to an Azure cloud.  The zero value is the Azure public cloud.
+type Cloud struct {
+	// Configuration holds the cloud's authority host and resource manager endpoint.
+	Configuration cloud.Configuration
+	// BlobEndpoint is the URL of a storage account's blob service with {account} in place of the account
+	// name.  Without {account} the account name is appended to the path, as Azurite expects.
+	BlobEndpoint string
+}
+
+var (
+	CloudPublic     = Cloud{}
+	CloudGovernment = Cloud{Configuration: cloud.AzureGovernment, BlobEndpoint: "https://{account}.blob.core.usgovcloudapi.net/"}
+	CloudChina      = Cloud{Configuration: cloud.AzureChina, BlobEndpoint: "https://{account}.blob.core.chinacloudapi.cn/"}
+)
+
+const publicBlobEndpoint = "https://{account}.blob.core.windows.net/"
+
+// NewCustomCloud returns a cloud with the given endpoints, such as an Azure Stack Hub or a local
+// emulator.  The public cloud's authority host is used when authorityHost is empty.
+func NewCustomCloud(authorityHost string, resourceManagerEndpoint string, blobEndpoint string) (Cloud, error) {
+	if resourceManagerEndpoint == "" || blobEndpoint == "" {
+		return Cloud{}, errors.New("a custom cloud needs both a resource manager endpoint and a blob endpoint")
+	}
+
+	for _, ep := range []string{authorityHost, resourceManagerEndpoint, strings.ReplaceAll(blobEndpoint, accountPlaceholder, "account")} {
+		if ep == "" {
+			continue
+		}
+		if u, err := url.Parse(ep); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return Cloud{}, fmt.Errorf("invalid endpoint url '%v'", ep)
+		}
+	}
+
+	if authorityHost == "" {
+		authorityHost = cloud.AzurePublic.ActiveDirectoryAuthorityHost
+	}
+
+	return Cloud{
+		Configuration: cloud.Configuration{
+			ActiveDirectoryAuthorityHost: authorityHost,
+			Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+				cloud.ResourceManager: {Endpoint: resourceManagerEndpoint, Audience: resourceManagerEndpoint},
+			},
+		},
+		BlobEndpoint: blobEndpoint,
+	}, nil
+}
+
+// resourceManagerEndpoint returns the base URL of the cloud's resource manager.
+func (c Cloud) resourceManagerEndpoint() string {
+	if conf, ok := c.Configuration.Services[cloud.ResourceManager]; ok && conf.Endpoint != "" {
+		return conf.Endpoint
+	}
+	return cloud.AzurePublic.Services[cloud.ResourceManager].Endpoint
+}
+
+// blobServiceUrl returns the URL of the named storage account's blob service.
+func (c Cloud) blobServiceUrl(accountName string) string {
+	ep := c.BlobEndpoint
+	if ep == "" {
+		ep = publicBlobEndpoint
+	}
+
+	if strings.Contains(ep, accountPlaceholder) {
+		return strings.TrimSuffix(strings.ReplaceAll(ep, accountPlaceholder, accountName), "/") + "/"
+	}
+
+	return strings.TrimSuffix(ep, "/") + "/" + accountName + "/"
+}
+
+func (c Cloud) clientOptions() azcore.ClientOptions {
+	return azcore.ClientOptions{Cloud: c.Configuration}
+}
+
+func (c Cloud) armClientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: c.clientOptions()}
+}
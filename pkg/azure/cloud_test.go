@@ -0,0 +1,95 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+func TestNewCustomCloud(t *testing.T) {
+	const arm = "https://management.local.azurestack.external/"
+	const blob = "https://{account}.blob.local.azurestack.external/"
+
+	tests := []struct {
+		name          string
+		authorityHost string
+		armEndpoint   string
+		blobEndpoint  string
+		wantErr       bool
+	}{
+		{name: "HostStyleBlobEndpoint", armEndpoint: arm, blobEndpoint: blob},
+		{name: "PathStyleBlobEndpoint", armEndpoint: "http://127.0.0.1:8080/", blobEndpoint: "http://127.0.0.1:10000"},
+		{name: "AuthorityHost", authorityHost: "https://login.local.azurestack.external/", armEndpoint: arm, blobEndpoint: blob},
+		{name: "MissingArmEndpoint", blobEndpoint: blob, wantErr: true},
+		{name: "MissingBlobEndpoint", armEndpoint: arm, wantErr: true},
+		{name: "ArmEndpointWithoutScheme", armEndpoint: "management.local.azurestack.external", blobEndpoint: blob, wantErr: true},
+		{name: "BlobEndpointWithoutScheme", armEndpoint: arm, blobEndpoint: "{account}.blob.local.azurestack.external", wantErr: true},
+		{name: "BlobEndpointWithOtherScheme", armEndpoint: arm, blobEndpoint: "ftp://{account}.blob.local.azurestack.external/", wantErr: true},
+		{name: "AuthorityHostWithoutHost", authorityHost: "https://", armEndpoint: arm, blobEndpoint: blob, wantErr: true},
+		{name: "InvalidArmEndpoint", armEndpoint: "https://management local", blobEndpoint: blob, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCustomCloud(tt.authorityHost, tt.armEndpoint, tt.blobEndpoint)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got cloud %+v", c)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			wantAuthority := tt.authorityHost
+			if wantAuthority == "" {
+				wantAuthority = cloud.AzurePublic.ActiveDirectoryAuthorityHost
+			}
+			if c.Configuration.ActiveDirectoryAuthorityHost != wantAuthority {
+				t.Errorf("unexpected authority host. want: %v, got: %v", wantAuthority, c.Configuration.ActiveDirectoryAuthorityHost)
+			}
+			if got := c.resourceManagerEndpoint(); got != tt.armEndpoint {
+				t.Errorf("unexpected resource manager endpoint. want: %v, got: %v", tt.armEndpoint, got)
+			}
+			if c.BlobEndpoint != tt.blobEndpoint {
+				t.Errorf("unexpected blob endpoint. want: %v, got: %v", tt.blobEndpoint, c.BlobEndpoint)
+			}
+		})
+	}
+}
+
+func TestBlobServiceUrl(t *testing.T) {
+	tests := []struct {
+		name         string
+		blobEndpoint string
+		want         string
+	}{
+		{"PublicCloud", "", "https://stgflowlogs.blob.core.windows.net/"},
+		{"HostStyle", "https://{account}.blob.core.usgovcloudapi.net/", "https://stgflowlogs.blob.core.usgovcloudapi.net/"},
+		{"HostStyleWithoutTrailingSlash", "https://{account}.blob.local.azurestack.external", "https://stgflowlogs.blob.local.azurestack.external/"},
+		{"PathStyle", "http://127.0.0.1:10000/", "http://127.0.0.1:10000/stgflowlogs/"},
+		{"PathStyleWithoutTrailingSlash", "http://127.0.0.1:10000", "http://127.0.0.1:10000/stgflowlogs/"},
+		{"PathStyleWithPlaceholder", "http://127.0.0.1:10000/{account}", "http://127.0.0.1:10000/stgflowlogs/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Cloud{BlobEndpoint: tt.blobEndpoint}
+			if got := c.blobServiceUrl("stgflowlogs"); got != tt.want {
+				t.Errorf("unexpected blob service url. want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("ServiceUrlScopesSas", func(t *testing.T) {
+		for _, ep := range []string{"https://{account}.blob.local.azurestack.external", "http://127.0.0.1:10000"} {
+			serviceUrl := Cloud{BlobEndpoint: ep}.blobServiceUrl("stgflowlogs")
+			if isContainer, ok := sasScope(serviceUrl+"insights-logs-networksecuritygroupflowevent?sig=x", serviceUrl); !ok || !isContainer {
+				t.Errorf("expected a container sas for endpoint %v, got container %v, ok %v", ep, isContainer, ok)
+			}
+		}
+	})
+}
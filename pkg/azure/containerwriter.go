@@ -0,0 +1,52 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// ContainerWriter writes block blobs to a container, staging each block separately so that the blobs
+// have the same block structure as flow logs written by Azure.
+type ContainerWriter struct {
+	client *azblob.ContainerClient
+}
+
+// NewContainerWriter returns a writer for the container at a SAS URL with write permission.
+func NewContainerWriter(containerSasUrl string) (*ContainerWriter, error) {
+	c, err := azblob.NewContainerClientWithNoCredential(containerSasUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container client: %w", err)
+	}
+
+	return &ContainerWriter{client: c}, nil
+}
+
+// WriteBlob creates or replaces the named blob with one made up of blocks.
+func (w *ContainerWriter) WriteBlob(ctx context.Context, name string, blocks [][]byte) error {
+	blob, err := w.client.NewBlockBlobClient(name)
+	if err != nil {
+		return fmt.Errorf("failed to create blob client: %w", err)
+	}
+
+	ids := make([]string, len(blocks))
+
+	for i, b := range blocks {
+		// block ids must all be the same length
+		ids[i] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", i)))
+
+		if _, err := blob.StageBlock(ctx, ids[i], streaming.NopCloser(bytes.NewReader(b)), nil); err != nil {
+			return fmt.Errorf("failed to stage block %v of blob %v: %w", i, name, err)
+		}
+	}
+
+	if _, err := blob.CommitBlockList(ctx, ids, nil); err != nil {
+		return fmt.Errorf("failed to commit blocks of blob %v: %w", name, err)
+	}
+
+	return nil
+}
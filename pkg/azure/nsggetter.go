@@ -2,84 +2,344 @@ package azure
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 )
 
-var ErrFlowLogsNotEnabled error = fmt.Errorf("nsg does not have flow logs enabled")
+var (
+	ErrFlowLogsNotEnabled error = fmt.Errorf("nsg does not have flow logs enabled")
+	ErrFlowLogNotFound    error = fmt.Errorf("nsg does not have the flow log")
+)
 
 type AzureNsgGetter struct {
-	nsgName string
-	ctx     context.Context
-	cred    *Credential
+	nsgName       string
+	resourceGroup string
+	ctx           context.Context
+	cred          *Credential
 }
 
-func NewAzureNsgGetter(nsgName string, ctx context.Context, cred *Credential) *AzureNsgGetter {
+// NewAzureNsgGetter creates a getter for the NSG with the given name or full resource ID.  When a
+// resource group is given the NSG is looked up directly in that group rather than by listing every
+// NSG in each subscription.
+func NewAzureNsgGetter(nsgName string, resourceGroup string, ctx context.Context, cred *Credential) *AzureNsgGetter {
 	return &AzureNsgGetter{
-		nsgName: nsgName,
-		ctx:     ctx,
-		cred:    cred,
+		nsgName:       nsgName,
+		resourceGroup: resourceGroup,
+		ctx:           ctx,
+		cred:          cred,
+	}
+}
+
+// IsNsgResourceId reports whether s is a full NSG resource ID rather than an NSG name.
+func IsNsgResourceId(s string) bool {
+	_, ok := parseNsgResourceId(s)
+	return ok
+}
+
+// IsNsgPattern reports whether s is a glob, such as nsg-hub-*, matching the names of several NSGs.
+func IsNsgPattern(s string) bool {
+	return !strings.HasPrefix(s, "/") && strings.ContainsAny(s, "*?[")
+}
+
+// nsgNameMatches reports whether an NSG's name matches a name or glob, ignoring case as Azure does.
+func nsgNameMatches(nameOrPattern string, name string) bool {
+	if IsNsgPattern(nameOrPattern) {
+		ok, _ := path.Match(strings.ToLower(nameOrPattern), strings.ToLower(name))
+		return ok
+	}
+	return strings.EqualFold(nameOrPattern, name)
+}
+
+// matchNsgs returns the NSGs in ids whose names match a name or glob and that are in resourceGroup when
+// one is given.  Names and resource groups are compared ignoring case.
+func matchNsgs(nameOrPattern string, resourceGroup string, ids []*arm.ResourceID) []*arm.ResourceID {
+	var matched []*arm.ResourceID
+
+	for _, id := range ids {
+		if resourceGroup != "" && !strings.EqualFold(id.ResourceGroupName, resourceGroup) {
+			continue
+		}
+		if nsgNameMatches(nameOrPattern, id.Name) {
+			matched = append(matched, id)
+		}
+	}
+
+	return matched
+}
+
+// NsgName returns the name of the NSG referred to by a name or full resource ID.
+func NsgName(nameOrId string) string {
+	if id, ok := parseNsgResourceId(nameOrId); ok {
+		return id.Name
+	}
+	return nameOrId
+}
+
+func parseNsgResourceId(s string) (*arm.ResourceID, bool) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, false
+	}
+
+	id, err := arm.ParseResourceID(s)
+	if err != nil || !strings.EqualFold(id.ResourceType.String(), "Microsoft.Network/networkSecurityGroups") {
+		return nil, false
+	}
+
+	return id, true
+}
+
+// AmbiguousNsgError is returned when more than one NSG matches the name being looked up.
+type AmbiguousNsgError struct {
+	Name       string
+	Candidates []string
+}
+
+func (e *AmbiguousNsgError) Error() string {
+	return fmt.Sprintf("found %v nsgs named '%v', pass one of these resource ids instead:\n  %v", len(e.Candidates), e.Name, strings.Join(e.Candidates, "\n  "))
+}
+
+// oneNsg returns the only NSG in ids, nil if there are none, or an *AmbiguousNsgError listing them if
+// there are several.
+func oneNsg(name string, ids []*arm.ResourceID) (*ResourceId, error) {
+	switch len(ids) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &ResourceId{*ids[0]}, nil
+	}
+
+	candidates := make([]string, 0, len(ids))
+	for _, id := range ids {
+		candidates = append(candidates, id.String())
 	}
+	sort.Strings(candidates)
+
+	return nil, &AmbiguousNsgError{Name: name, Candidates: candidates}
 }
 
-func (a *AzureNsgGetter) GetNsgFlowLogStorageId(subscriptionIds []string) (*ResourceId, error) {
-	log.Print("finding nsg")
+// FindNsg returns the resource ID of the NSG, looking it up by name in the given subscriptions unless
+// the getter was created with a full resource ID.  Names match case-insensitively, as they do in Azure,
+// and an *AmbiguousNsgError is returned if more than one NSG matches.
+func (a *AzureNsgGetter) FindNsg(subscriptionIds []string) (*ResourceId, error) {
+	if id, ok := parseNsgResourceId(a.nsgName); ok {
+		return &ResourceId{*id}, nil
+	}
+
+	var ids []*arm.ResourceID
+	var err error
+
+	if a.resourceGroup != "" {
+		ids, err = a.findNsgsInResourceGroup(subscriptionIds)
+	} else {
+		ids, err = a.findNsgs(subscriptionIds)
+	}
 
-	nsgId, err := a.findNsg(subscriptionIds)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Print("getting nsg")
+	nsgId, err := oneNsg(a.nsgName, ids)
+	if err != nil || nsgId != nil {
+		return nsgId, err
+	}
+
+	if a.resourceGroup != "" {
+		return nil, fmt.Errorf("could not find nsg '%v' in resource group '%v' in subscriptions: %v", a.nsgName, a.resourceGroup, subscriptionIds)
+	}
+	return nil, fmt.Errorf("could not find nsg '%v' in subscriptions: %v", a.nsgName, subscriptionIds)
+}
+
+// FindNsgs returns the resource IDs of every NSG whose name matches the glob the getter was created
+// with, looking in the resource group if one was given and otherwise in every subscription.
+func (a *AzureNsgGetter) FindNsgs(subscriptionIds []string) ([]*ResourceId, error) {
+	if _, err := path.Match(a.nsgName, ""); err != nil {
+		return nil, fmt.Errorf("invalid nsg name pattern '%v': %w", a.nsgName, err)
+	}
+
+	var ids []*arm.ResourceID
+	var err error
+
+	if a.resourceGroup != "" {
+		ids, err = a.searchResourceGroupsForNsgs(subscriptionIds)
+	} else {
+		ids, err = a.findNsgs(subscriptionIds)
+	}
 
-	nsg, err := a.getNsgById(nsgId)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(nsg.Properties.FlowLogs) == 0 {
-		return nil, ErrFlowLogsNotEnabled
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("could not find any nsgs matching '%v' in subscriptions: %v", a.nsgName, subscriptionIds)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return strings.ToLower(ids[i].String()) < strings.ToLower(ids[j].String()) })
+
+	nsgIds := make([]*ResourceId, 0, len(ids))
+	for _, id := range ids {
+		nsgIds = append(nsgIds, &ResourceId{*id})
 	}
 
-	stgId, err := arm.ParseResourceID(*nsg.Properties.FlowLogs[0].Properties.StorageID)
+	return nsgIds, nil
+}
+
+// FlowLog describes one of the flow log resources attached to an NSG.
+type FlowLog struct {
+	Name      string
+	Location  string
+	StorageId *ResourceId
+	Enabled   bool
+	// Version is the flow log format version, 1 or 2
+	Version int
+	// RetentionDays is how long logs are kept for, zero means forever or that retention is disabled
+	RetentionDays int
+}
+
+// GetNsgFlowLogs returns every flow log attached to the NSG.
+func (a *AzureNsgGetter) GetNsgFlowLogs(nsgId *ResourceId) ([]FlowLog, error) {
+	log.Print("getting nsg")
+
+	nsg, err := a.getNsgById(&nsgId.ResourceID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ResourceId{*stgId}, nil
+	flowLogs := make([]FlowLog, 0, len(nsg.Properties.FlowLogs))
+
+	for _, fl := range nsg.Properties.FlowLogs {
+		if fl.Properties == nil || fl.Properties.StorageID == nil {
+			continue
+		}
+
+		stgId, err := arm.ParseResourceID(*fl.Properties.StorageID)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse flow log storage id %v: %w", *fl.Properties.StorageID, err)
+		}
+
+		f := FlowLog{
+			Name:      stringValue(fl.Name),
+			Location:  stringValue(fl.Location),
+			StorageId: &ResourceId{*stgId},
+			Enabled:   fl.Properties.Enabled != nil && *fl.Properties.Enabled,
+			Version:   1,
+		}
+
+		if fl.Properties.Format != nil && fl.Properties.Format.Version != nil {
+			f.Version = int(*fl.Properties.Format.Version)
+		}
+
+		if rp := fl.Properties.RetentionPolicy; rp != nil && rp.Enabled != nil && *rp.Enabled && rp.Days != nil {
+			f.RetentionDays = int(*rp.Days)
+		}
+
+		flowLogs = append(flowLogs, f)
+	}
+
+	return flowLogs, nil
+}
+
+// SelectFlowLogs returns the flow log with the given name, or every enabled flow log when name is empty.
+func SelectFlowLogs(flowLogs []FlowLog, name string) ([]FlowLog, error) {
+	if name != "" {
+		names := make([]string, 0, len(flowLogs))
+		for _, fl := range flowLogs {
+			if strings.EqualFold(fl.Name, name) {
+				return []FlowLog{fl}, nil
+			}
+			names = append(names, fl.Name)
+		}
+		return nil, fmt.Errorf("%w '%v', available flow logs: %v", ErrFlowLogNotFound, name, names)
+	}
+
+	var enabled []FlowLog
+	for _, fl := range flowLogs {
+		if fl.Enabled {
+			enabled = append(enabled, fl)
+		}
+	}
+
+	if len(enabled) == 0 {
+		return nil, ErrFlowLogsNotEnabled
+	}
+
+	return enabled, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
 }
 
 func (a *AzureNsgGetter) newNsgClient(subscriptionId string) (*armnetwork.SecurityGroupsClient, error) {
-	c, err := armnetwork.NewSecurityGroupsClient(subscriptionId, *a.cred, nil)
+	c, err := armnetwork.NewSecurityGroupsClient(subscriptionId, *a.cred, a.cred.Cloud.armClientOptions())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create nsg client: %w", err)
 	}
 	return c, nil
 }
 
-func (a *AzureNsgGetter) findNsg(subscriptionIds []string) (*arm.ResourceID, error) {
+// findNsgsInResourceGroup gets the NSG from the resource group in each subscription.
+func (a *AzureNsgGetter) findNsgsInResourceGroup(subscriptionIds []string) ([]*arm.ResourceID, error) {
+	var ids []*arm.ResourceID
+
 	for _, subId := range subscriptionIds {
-		nsgId, err := a.searchSubForNsg(subId, a.nsgName)
+		client, err := a.newNsgClient(subId)
 		if err != nil {
 			return nil, err
 		}
-		if nsgId != nil {
-			return nsgId, nil
+
+		nsg, err := client.Get(a.ctx, a.resourceGroup, a.nsgName, nil)
+		if err != nil {
+			var respErr *azcore.ResponseError
+			if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get nsg: %w", err)
+		}
+
+		r, err := arm.ParseResourceID(*nsg.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse nsg resource id %v: %w", *nsg.ID, err)
+		}
+
+		ids = append(ids, r)
+	}
+
+	return ids, nil
+}
+
+func (a *AzureNsgGetter) findNsgs(subscriptionIds []string) ([]*arm.ResourceID, error) {
+	var ids []*arm.ResourceID
+
+	for _, subId := range subscriptionIds {
+		found, err := a.searchSubForNsgs(subId, a.nsgName)
+		if err != nil {
+			return nil, err
 		}
+		ids = append(ids, found...)
 	}
 
-	return nil, fmt.Errorf("could not find nsg '%v' in subscriptions: %v", a.nsgName, subscriptionIds)
+	return ids, nil
 }
 
-func (a *AzureNsgGetter) searchSubForNsg(subscriptionId string, nsgName string) (*arm.ResourceID, error) {
+func (a *AzureNsgGetter) searchSubForNsgs(subscriptionId string, nsgName string) ([]*arm.ResourceID, error) {
 	client, err := a.newNsgClient(subscriptionId)
 	if err != nil {
 		return nil, err
 	}
 
+	var ids []*arm.ResourceID
 	pager := client.NewListAllPager(nil)
 
 	for pager.More() {
@@ -88,19 +348,62 @@ func (a *AzureNsgGetter) searchSubForNsg(subscriptionId string, nsgName string)
 			return nil, fmt.Errorf("failed to retrieve nsgs: %w", err)
 		}
 
-		for _, n := range page.Value {
-			if *n.Name == nsgName {
-				r, err := arm.ParseResourceID(*n.ID)
-				if err != nil {
-					return nil, fmt.Errorf("could not parse nsg resource id %v: %w", n.ID, err)
+		pageIds, err := parseNsgIds(page.Value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, matchNsgs(nsgName, "", pageIds)...)
+	}
+
+	return ids, nil
+}
+
+func parseNsgIds(nsgs []*armnetwork.SecurityGroup) ([]*arm.ResourceID, error) {
+	ids := make([]*arm.ResourceID, 0, len(nsgs))
+
+	for _, n := range nsgs {
+		r, err := arm.ParseResourceID(*n.ID)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse nsg resource id %v: %w", *n.ID, err)
+		}
+		ids = append(ids, r)
+	}
+
+	return ids, nil
+}
+
+// searchResourceGroupsForNsgs lists the NSGs in the resource group in each subscription that match
+// the getter's glob.
+func (a *AzureNsgGetter) searchResourceGroupsForNsgs(subscriptionIds []string) ([]*arm.ResourceID, error) {
+	var ids []*arm.ResourceID
+
+	for _, subId := range subscriptionIds {
+		client, err := a.newNsgClient(subId)
+		if err != nil {
+			return nil, err
+		}
+
+		pager := client.NewListPager(a.resourceGroup, nil)
+
+		for pager.More() {
+			page, err := pager.NextPage(a.ctx)
+			if err != nil {
+				var respErr *azcore.ResponseError
+				if errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound {
+					break
 				}
+				return nil, fmt.Errorf("failed to retrieve nsgs: %w", err)
+			}
 
-				return r, nil
+			pageIds, err := parseNsgIds(page.Value)
+			if err != nil {
+				return nil, err
 			}
+			ids = append(ids, matchNsgs(a.nsgName, a.resourceGroup, pageIds)...)
 		}
 	}
 
-	return nil, nil
+	return ids, nil
 }
 
 func (a *AzureNsgGetter) getNsgById(nsgId *arm.ResourceID) (*armnetwork.SecurityGroupsClientGetResponse, error) {
@@ -0,0 +1,140 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+)
+
+const testSubscriptionId = "00000000-0000-0000-0000-000000000000"
+
+func testNsgId(t *testing.T, resourceGroup string, name string) *arm.ResourceID {
+	id, err := arm.ParseResourceID("/subscriptions/" + testSubscriptionId + "/resourceGroups/" + resourceGroup + "/providers/Microsoft.Network/networkSecurityGroups/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestFindingNsgByName(t *testing.T) {
+	hub := testNsgId(t, "rg-hub", "nsg-hub")
+	spoke := testNsgId(t, "rg-spoke", "NSG-Spoke")
+	sharedHub := testNsgId(t, "rg-hub", "nsg-shared")
+	sharedSpoke := testNsgId(t, "rg-spoke", "nsg-shared")
+	all := []*arm.ResourceID{hub, spoke, sharedHub, sharedSpoke}
+
+	tests := []struct {
+		name          string
+		nsgName       string
+		resourceGroup string
+		want          *arm.ResourceID
+		ambiguous     []string
+	}{
+		{name: "ExactMatch", nsgName: "nsg-hub", want: hub},
+		{name: "NameDiffersInCase", nsgName: "nsg-spoke", want: spoke},
+		{name: "QualifiedByResourceGroup", nsgName: "nsg-shared", resourceGroup: "rg-spoke", want: sharedSpoke},
+		{name: "ResourceGroupDiffersInCase", nsgName: "nsg-shared", resourceGroup: "RG-HUB", want: sharedHub},
+		{name: "NotInResourceGroup", nsgName: "nsg-hub", resourceGroup: "rg-spoke"},
+		{name: "NoMatch", nsgName: "nsg-missing"},
+		{name: "PrefixIsNotAMatch", nsgName: "nsg"},
+		{
+			name:      "SameNameInTwoResourceGroups",
+			nsgName:   "nsg-shared",
+			ambiguous: []string{sharedHub.String(), sharedSpoke.String()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := oneNsg(tt.nsgName, matchNsgs(tt.nsgName, tt.resourceGroup, all))
+
+			if tt.ambiguous != nil {
+				var ambiguousErr *AmbiguousNsgError
+				if !errors.As(err, &ambiguousErr) {
+					t.Fatalf("expected an ambiguous nsg error, got %v", err)
+				}
+				if ambiguousErr.Name != tt.nsgName || !reflect.DeepEqual(ambiguousErr.Candidates, tt.ambiguous) {
+					t.Errorf("unexpected candidates. want: %v, got: %v", tt.ambiguous, ambiguousErr.Candidates)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("expected no match, got %v", got)
+			case tt.want != nil && (got == nil || got.String() != tt.want.String()):
+				t.Errorf("unexpected nsg. want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("GlobMatchesEveryNsgIgnoringCase", func(t *testing.T) {
+		got := matchNsgs("NSG-S*", "", all)
+		if want := []*arm.ResourceID{spoke, sharedHub, sharedSpoke}; !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected matches. want: %v, got: %v", want, got)
+		}
+	})
+
+	t.Run("ResourceIdIsUsedWithoutLookingItUp", func(t *testing.T) {
+		// there are no credentials, so this fails if the nsg is looked up
+		getter := NewAzureNsgGetter(sharedSpoke.String(), "", context.Background(), nil)
+
+		got, err := getter.FindNsg([]string{testSubscriptionId})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got.String() != sharedSpoke.String() {
+			t.Errorf("unexpected nsg. want: %v, got: %v", sharedSpoke, got)
+		}
+	})
+}
+
+func TestSelectFlowLogs(t *testing.T) {
+	enabled := FlowLog{Name: "fl-enabled", Enabled: true}
+	second := FlowLog{Name: "fl-second", Enabled: true}
+	disabled := FlowLog{Name: "fl-disabled"}
+
+	tests := []struct {
+		name     string
+		flowLogs []FlowLog
+		selected string
+		want     []FlowLog
+		wantErr  error
+	}{
+		{name: "EveryEnabledFlowLog", flowLogs: []FlowLog{enabled, disabled, second}, want: []FlowLog{enabled, second}},
+		{name: "OnlyEnabledFlowLog", flowLogs: []FlowLog{disabled, enabled}, want: []FlowLog{enabled}},
+		{name: "NoneEnabled", flowLogs: []FlowLog{disabled}, wantErr: ErrFlowLogsNotEnabled},
+		{name: "NoFlowLogs", wantErr: ErrFlowLogsNotEnabled},
+		{name: "NamedFlowLog", flowLogs: []FlowLog{enabled, second}, selected: "fl-second", want: []FlowLog{second}},
+		{name: "NameDiffersInCase", flowLogs: []FlowLog{enabled, second}, selected: "FL-SECOND", want: []FlowLog{second}},
+		{name: "NamedFlowLogCanBeDisabled", flowLogs: []FlowLog{enabled, disabled}, selected: "fl-disabled", want: []FlowLog{disabled}},
+		{name: "NamedFlowLogMissing", flowLogs: []FlowLog{enabled}, selected: "fl-missing", wantErr: ErrFlowLogNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectFlowLogs(tt.flowLogs, tt.selected)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("unexpected error. want: %v, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected flow logs. want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
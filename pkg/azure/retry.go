@@ -0,0 +1,103 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// RetryPolicy controls how blob operations are retried.  Attempts are spaced by exponential backoff
+// starting at BaseDelay and capped at MaxDelay.  A zero policy makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Second * 30,
+}
+
+// do runs op until it succeeds, returns an error that can't be retried, runs out of attempts or ctx
+// is cancelled.  Errors after more than one attempt say how many were made and why retrying stopped.
+func (p RetryPolicy) do(ctx context.Context, op func() error) error {
+	var err error
+	stopped := "no attempts left"
+	attempt := 1
+
+retry:
+	for ; ; attempt++ {
+		err = op()
+
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() != nil:
+			stopped = "context cancelled"
+			break retry
+		case !isRetryable(err):
+			stopped = "error can't be retried"
+			break retry
+		case attempt >= p.MaxAttempts:
+			break retry
+		}
+
+		select {
+		case <-ctx.Done():
+			stopped = "context cancelled"
+			break retry
+		case <-time.After(p.backoff(attempt)):
+		}
+	}
+
+	if attempt == 1 {
+		return err
+	}
+	return fmt.Errorf("stopped retrying after %v, %v: %w", attempts(attempt), stopped, err)
+}
+
+func attempts(n int) string {
+	if n == 1 {
+		return "1 attempt"
+	}
+	return fmt.Sprintf("%v attempts", n)
+}
+
+// backoff returns the delay before the attempt following the given one.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+
+	return d
+}
+
+// isRetryable reports whether err might succeed if tried again.  Storage errors are only retried when
+// the service was busy or failed and local file errors never are.  Anything else, such as a network
+// error, is assumed to be transient.
+func isRetryable(err error) bool {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+
+	var stgErr *azblob.StorageError
+	if errors.As(err, &stgErr) && stgErr.Response() != nil {
+		code := stgErr.Response().StatusCode
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+
+	return true
+}
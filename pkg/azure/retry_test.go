@@ -0,0 +1,191 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// storageError returns the error the storage client gives for a response with the given status code.
+func storageError(t *testing.T, status int) error {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", "TestError")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// leave retrying to the policy under test
+	opts := &azblob.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}}
+	c, err := azblob.NewContainerClientWithNoCredential(server.URL+"/account/container", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetProperties(context.Background(), nil)
+	if err == nil {
+		t.Fatalf("expected an error for status %v", status)
+	}
+	return err
+}
+
+func TestBackoff(t *testing.T) {
+	capped := RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Second * 30}
+	uncapped := RetryPolicy{BaseDelay: time.Second}
+
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"FirstRetryWaitsBaseDelay", capped, 1, time.Second},
+		{"DoublesEachAttempt", capped, 3, time.Second * 4},
+		{"LastDelayBelowCap", capped, 5, time.Second * 16},
+		{"CappedAtMaxDelay", capped, 6, time.Second * 30},
+		{"StaysAtMaxDelay", capped, 50, time.Second * 30},
+		{"UncappedWithoutMaxDelay", uncapped, 7, time.Second * 64},
+		{"MaxDelayBelowBaseDelay", RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Millisecond}, 1, time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.backoff(tt.attempt); got != tt.want {
+				t.Errorf("unexpected backoff. want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"RequestTimeout", storageError(t, http.StatusRequestTimeout), true},
+		{"TooManyRequests", storageError(t, http.StatusTooManyRequests), true},
+		{"InternalServerError", storageError(t, http.StatusInternalServerError), true},
+		{"ServiceUnavailable", storageError(t, http.StatusServiceUnavailable), true},
+		{"BadRequest", storageError(t, http.StatusBadRequest), false},
+		{"Forbidden", storageError(t, http.StatusForbidden), false},
+		{"NotFound", storageError(t, http.StatusNotFound), false},
+		{"LocalFileError", &fs.PathError{Op: "open", Path: "PT1H.json", Err: fs.ErrNotExist}, false},
+		{"NetworkError", errors.New("connection reset by peer"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("unexpected result for %v. want: %v, got: %v", tt.err, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	retry := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond * 5}
+	transient := errors.New("connection reset by peer")
+
+	// failing returns an op that fails with each of errs in turn and then succeeds, counting its calls
+	failing := func(calls *int, errs ...error) func() error {
+		return func() error {
+			*calls++
+			if *calls <= len(errs) {
+				return errs[*calls-1]
+			}
+			return nil
+		}
+	}
+
+	t.Run("RetriesUntilSuccess", func(t *testing.T) {
+		var calls int
+		if err := retry.do(context.Background(), failing(&calls, transient, transient)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != 3 {
+			t.Errorf("unexpected number of attempts. want: 3, got: %v", calls)
+		}
+	})
+
+	t.Run("GivesUpAfterMaxAttempts", func(t *testing.T) {
+		var calls int
+		err := retry.do(context.Background(), failing(&calls, transient, transient, transient, transient))
+		if !errors.Is(err, transient) || !strings.Contains(err.Error(), "after 3 attempts, no attempts left") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if calls != 3 {
+			t.Errorf("unexpected number of attempts. want: 3, got: %v", calls)
+		}
+	})
+
+	t.Run("ReturnsErrorThatCantBeRetriedUnchanged", func(t *testing.T) {
+		var calls int
+		notFound := storageError(t, http.StatusNotFound)
+		if err := retry.do(context.Background(), failing(&calls, notFound)); err != notFound {
+			t.Errorf("unexpected error. want: %v, got: %v", notFound, err)
+		}
+		if calls != 1 {
+			t.Errorf("unexpected number of attempts. want: 1, got: %v", calls)
+		}
+	})
+
+	t.Run("ReportsAttemptsBeforeErrorThatCantBeRetried", func(t *testing.T) {
+		var calls int
+		err := retry.do(context.Background(), failing(&calls, transient, storageError(t, http.StatusForbidden)))
+		if err == nil || !strings.Contains(err.Error(), "after 2 attempts, error can't be retried") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("StopsWhenCancelledDuringBackoff", func(t *testing.T) {
+		slow := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Millisecond*50, cancel)
+
+		var calls int
+		errCh := make(chan error)
+		go func() { errCh <- slow.do(ctx, failing(&calls, transient, transient, transient)) }()
+
+		select {
+		case err := <-errCh:
+			if err != transient {
+				t.Errorf("unexpected error. want: %v, got: %v", transient, err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("retrying didn't stop when the context was cancelled")
+		}
+	})
+
+	t.Run("ReportsAttemptsBeforeCancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var calls int
+		op := func() error {
+			if calls++; calls == 2 {
+				cancel()
+			}
+			return transient
+		}
+
+		err := retry.do(ctx, op)
+		if !errors.Is(err, transient) || !strings.Contains(err.Error(), "after 2 attempts, context cancelled") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("SingleAttemptPolicyReturnsErrorUnchanged", func(t *testing.T) {
+		var calls int
+		if err := (RetryPolicy{}).do(context.Background(), failing(&calls, transient)); err != transient {
+			t.Errorf("unexpected error. want: %v, got: %v", transient, err)
+		}
+	})
+}
@@ -2,7 +2,12 @@ package azure
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/storage/armstorage"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
@@ -10,16 +15,49 @@ import (
 
 var flowLogBlobContainerName string = "insights-logs-networksecuritygroupflowevent"
 
+// StorageAuthMode selects how NewAzureStorageBlobGetter authenticates to a flow log storage account.
+type StorageAuthMode int
+
+const (
+	// StorageAuthAuto tries a SAS URL if one is given, then the user's token and then account keys,
+	// using the first that can list the flow log container.
+	StorageAuthAuto StorageAuthMode = iota
+	StorageAuthToken
+	StorageAuthKey
+	StorageAuthSas
+)
+
+func (m StorageAuthMode) String() string {
+	switch m {
+	case StorageAuthToken:
+		return "token"
+	case StorageAuthKey:
+		return "account key"
+	case StorageAuthSas:
+		return "sas"
+	default:
+		return "auto"
+	}
+}
+
+type StorageOptions struct {
+	Mode StorageAuthMode
+	// SasUrl is a SAS URL for either the storage account's blob service or the flow log container.
+	SasUrl string
+}
+
 type AzureStorageBlobGetter struct {
 	ctx             context.Context
 	cred            *Credential
+	opts            StorageOptions
 	containerClient *azblob.ContainerClient
 }
 
-func NewAzureStorageBlobGetter(ctx context.Context, cred *Credential, stgAccId *ResourceId) (*AzureStorageBlobGetter, error) {
+func NewAzureStorageBlobGetter(ctx context.Context, cred *Credential, stgAccId *ResourceId, opts StorageOptions) (*AzureStorageBlobGetter, error) {
 	a := AzureStorageBlobGetter{
 		ctx:  ctx,
 		cred: cred,
+		opts: opts,
 	}
 
 	c, err := a.getContainerClient(stgAccId)
@@ -31,7 +69,92 @@ func NewAzureStorageBlobGetter(ctx context.Context, cred *Credential, stgAccId *
 	return &a, nil
 }
 
+// getContainerClient returns a client for the flow log container using the chosen auth mode.  In auto
+// mode each method is tried in turn until one of them is allowed to list the container.
 func (a *AzureStorageBlobGetter) getContainerClient(stgAccId *ResourceId) (*azblob.ContainerClient, error) {
+	if a.opts.Mode != StorageAuthAuto {
+		return a.newContainerClient(a.opts.Mode, stgAccId)
+	}
+
+	modes := []StorageAuthMode{StorageAuthToken, StorageAuthKey}
+	if _, ok := sasScope(a.opts.SasUrl, a.cred.Cloud.blobServiceUrl(stgAccId.Name)); ok {
+		modes = append([]StorageAuthMode{StorageAuthSas}, modes...)
+	}
+
+	var failures []string
+
+	for _, m := range modes {
+		c, err := a.newContainerClient(m, stgAccId)
+		if err == nil {
+			err = a.checkAccess(c)
+		}
+		if err == nil {
+			log.Printf("using %v authentication for storage account %v", m, stgAccId.Name)
+			return c, nil
+		}
+		if a.ctx.Err() != nil {
+			return nil, a.ctx.Err()
+		}
+
+		log.Printf("%v authentication failed for storage account %v: %v", m, stgAccId.Name, err)
+		failures = append(failures, fmt.Sprintf("%v: %v", m, err))
+	}
+
+	return nil, fmt.Errorf("failed to access storage account %v with any authentication method:\n  %v", stgAccId.Name, strings.Join(failures, "\n  "))
+}
+
+func (a *AzureStorageBlobGetter) newContainerClient(mode StorageAuthMode, stgAccId *ResourceId) (*azblob.ContainerClient, error) {
+	switch mode {
+	case StorageAuthToken:
+		return a.newTokenContainerClient(stgAccId)
+	case StorageAuthSas:
+		return a.newSasContainerClient(stgAccId)
+	default:
+		return a.newKeyContainerClient(stgAccId)
+	}
+}
+
+func (a *AzureStorageBlobGetter) newTokenContainerClient(stgAccId *ResourceId) (*azblob.ContainerClient, error) {
+	serviceClient, err := azblob.NewServiceClient(a.cred.Cloud.blobServiceUrl(stgAccId.Name), *a.cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob service client: %w", err)
+	}
+
+	return newFlowLogContainerClient(serviceClient)
+}
+
+// newSasContainerClient accepts a SAS URL for either the blob service or the flow log container itself.
+func (a *AzureStorageBlobGetter) newSasContainerClient(stgAccId *ResourceId) (*azblob.ContainerClient, error) {
+	if a.opts.SasUrl == "" {
+		return nil, errors.New("a sas url is required for sas authentication")
+	}
+
+	isContainer, ok := sasScope(a.opts.SasUrl, a.cred.Cloud.blobServiceUrl(stgAccId.Name))
+	if !ok {
+		return nil, fmt.Errorf("sas url is not for storage account %v", stgAccId.Name)
+	}
+
+	if err := checkSasToken(a.opts.SasUrl, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if isContainer {
+		containerClient, err := azblob.NewContainerClientWithNoCredential(a.opts.SasUrl, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create container client: %w", err)
+		}
+		return containerClient, nil
+	}
+
+	serviceClient, err := azblob.NewServiceClientWithNoCredential(a.opts.SasUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create blob service client: %w", err)
+	}
+
+	return newFlowLogContainerClient(serviceClient)
+}
+
+func (a *AzureStorageBlobGetter) newKeyContainerClient(stgAccId *ResourceId) (*azblob.ContainerClient, error) {
 	stgAccClient, err := a.newStorageAccountClient(stgAccId.SubscriptionID)
 	if err != nil {
 		return nil, err
@@ -47,11 +170,28 @@ func (a *AzureStorageBlobGetter) getContainerClient(stgAccId *ResourceId) (*azbl
 		return nil, fmt.Errorf("failed to created blob credential: %w", err)
 	}
 
-	serviceClient, err := azblob.NewServiceClientWithSharedKey(fmt.Sprintf("https://%s.blob.core.windows.net/", stgAccId.Name), blobCred, nil)
+	serviceClient, err := azblob.NewServiceClientWithSharedKey(a.cred.Cloud.blobServiceUrl(stgAccId.Name), blobCred, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create blob service client: %w", err)
 	}
 
+	return newFlowLogContainerClient(serviceClient)
+}
+
+// checkAccess lists a single blob to make sure the client is allowed to read the container.
+func (a *AzureStorageBlobGetter) checkAccess(c *azblob.ContainerClient) error {
+	maxResults := int32(1)
+	pager := c.ListBlobsFlat(&azblob.ContainerListBlobsFlatOptions{MaxResults: &maxResults})
+	pager.NextPage(a.ctx)
+
+	if err := pager.Err(); err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	return nil
+}
+
+func newFlowLogContainerClient(serviceClient *azblob.ServiceClient) (*azblob.ContainerClient, error) {
 	containerClient, err := serviceClient.NewContainerClient(flowLogBlobContainerName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create container client: %w", err)
@@ -60,8 +200,82 @@ func (a *AzureStorageBlobGetter) getContainerClient(stgAccId *ResourceId) (*azbl
 	return containerClient, nil
 }
 
+// sasScope reports whether a SAS URL is for the blob service at serviceUrl, so that a SAS for one account
+// isn't tried against flow logs stored in another, and whether it is for a container in that service
+// rather than the service itself.
+func sasScope(sasUrl string, serviceUrl string) (isContainer bool, ok bool) {
+	sas, err := url.Parse(sasUrl)
+	if err != nil || sasUrl == "" {
+		return false, false
+	}
+
+	svc, err := url.Parse(serviceUrl)
+	if err != nil || !strings.EqualFold(sas.Scheme, svc.Scheme) || !strings.EqualFold(sas.Host, svc.Host) {
+		return false, false
+	}
+
+	sasPath := strings.Trim(sas.Path, "/")
+	svcPath := strings.Trim(svc.Path, "/")
+
+	switch {
+	case strings.EqualFold(sasPath, svcPath):
+		return false, true
+	case svcPath == "":
+		return true, true
+	case len(sasPath) > len(svcPath) && strings.EqualFold(sasPath[:len(svcPath)+1], svcPath+"/"):
+		return true, true
+	}
+
+	return false, false
+}
+
+// checkSasToken makes sure a SAS URL can list and read flow logs and hasn't expired, so that an unusable
+// token fails with a clear error instead of a 403.  Fields that a stored access policy can supply in its
+// place are only checked when the token has them.
+func checkSasToken(sasUrl string, now time.Time) error {
+	u, err := url.Parse(sasUrl)
+	if err != nil {
+		return fmt.Errorf("invalid sas url: %w", err)
+	}
+	q := u.Query()
+
+	if sp := q.Get("sp"); sp != "" && (!strings.Contains(sp, "r") || !strings.Contains(sp, "l")) {
+		return fmt.Errorf("sas token needs read (r) and list (l) permissions, got sp=%v", sp)
+	}
+
+	// account SAS tokens also say which services and resource types they cover
+	if ss := q.Get("ss"); ss != "" && !strings.Contains(ss, "b") {
+		return fmt.Errorf("sas token isn't for the blob service, got ss=%v", ss)
+	}
+	if srt := q.Get("srt"); srt != "" && (!strings.Contains(srt, "c") || !strings.Contains(srt, "o")) {
+		return fmt.Errorf("sas token needs container (c) and object (o) resource types, got srt=%v", srt)
+	}
+
+	if se := q.Get("se"); se != "" {
+		expiry, err := parseSasTime(se)
+		if err != nil {
+			return fmt.Errorf("invalid sas expiry %v: %w", se, err)
+		}
+		if !expiry.After(now) {
+			return fmt.Errorf("sas token expired at %v", expiry.Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+// parseSasTime parses a SAS start or expiry time, which may be given as a date or a UTC time.
+func parseSasTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time format")
+}
+
 func (a *AzureStorageBlobGetter) newStorageAccountClient(subscriptionId string) (*armstorage.AccountsClient, error) {
-	stgClient, err := armstorage.NewAccountsClient(subscriptionId, *a.cred, nil)
+	stgClient, err := armstorage.NewAccountsClient(subscriptionId, *a.cred, a.cred.Cloud.armClientOptions())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create storage account client: %w", err)
 	}
@@ -110,7 +324,7 @@ func (a *AzureStorageBlobGetter) ListBlobDirectory(prefix string) (blobs []Blob,
 				return nil, nil, fmt.Errorf("failed to get blob client for blob %v: %w", b.Name, err)
 			}
 
-			blobs = append(blobs, Blob{*client, *b.Name, *b.Properties.LastModified})
+			blobs = append(blobs, Blob{&blockBlobStore{*client}, *b.Name, *b.Properties.LastModified, contentLength(b.Properties.ContentLength), RetryPolicy{}})
 		}
 
 		for _, p := range resp.Segment.BlobPrefixes {
@@ -137,7 +351,7 @@ func (a *AzureStorageBlobGetter) ListBlobs(prefix string) (blobs []Blob, err err
 				return nil, err
 			}
 
-			blobs = append(blobs, Blob{*bb, *b.Name, *b.Properties.LastModified})
+			blobs = append(blobs, Blob{&blockBlobStore{*bb}, *b.Name, *b.Properties.LastModified, contentLength(b.Properties.ContentLength), RetryPolicy{}})
 		}
 	}
 
@@ -147,3 +361,10 @@ func (a *AzureStorageBlobGetter) ListBlobs(prefix string) (blobs []Blob, err err
 
 	return
 }
+
+func contentLength(l *int64) int64 {
+	if l == nil {
+		return 0
+	}
+	return *l
+}
@@ -0,0 +1,84 @@
+package azure
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSasScope(t *testing.T) {
+	const hostService = "https://stgflowlogs.blob.core.windows.net/"
+	const pathService = "http://127.0.0.1:10000/devstoreaccount1"
+
+	tests := []struct {
+		name           string
+		sasUrl         string
+		serviceUrl     string
+		wantContainer  bool
+		wantForAccount bool
+	}{
+		{"AccountSas", "https://stgflowlogs.blob.core.windows.net/?sv=2020-10-02&ss=b&srt=co&sp=rl&sig=x", hostService, false, true},
+		{"ContainerSas", "https://stgflowlogs.blob.core.windows.net/insights-logs-networksecuritygroupflowevent?sv=2020-10-02&sr=c&sp=rl&sig=x", hostService, true, true},
+		{"HostDiffersInCase", "https://STGFLOWLOGS.blob.core.windows.net/?sig=x", hostService, false, true},
+		{"OtherAccount", "https://otheraccount.blob.core.windows.net/?sig=x", hostService, false, false},
+		{"OtherScheme", "http://stgflowlogs.blob.core.windows.net/?sig=x", hostService, false, false},
+		{"PathStyleAccountSas", "http://127.0.0.1:10000/devstoreaccount1?sig=x", pathService, false, true},
+		{"PathStyleContainerSas", "http://127.0.0.1:10000/devstoreaccount1/insights-logs-networksecuritygroupflowevent?sig=x", pathService, true, true},
+		{"PathStyleOtherAccount", "http://127.0.0.1:10000/devstoreaccount2/insights-logs-networksecuritygroupflowevent?sig=x", pathService, false, false},
+		{"PathStyleAccountPrefix", "http://127.0.0.1:10000/devstoreaccount10?sig=x", pathService, false, false},
+		{"Empty", "", hostService, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isContainer, ok := sasScope(tt.sasUrl, tt.serviceUrl)
+			if ok != tt.wantForAccount || isContainer != tt.wantContainer {
+				t.Errorf("unexpected scope. want: container %v, ok %v, got: container %v, ok %v", tt.wantContainer, tt.wantForAccount, isContainer, ok)
+			}
+		})
+	}
+}
+
+func TestCheckSasToken(t *testing.T) {
+	now := time.Date(2022, 8, 9, 10, 0, 0, 0, time.UTC)
+	const container = "https://stgflowlogs.blob.core.windows.net/insights-logs-networksecuritygroupflowevent?sv=2020-10-02&sr=c&sig=x"
+	const account = "https://stgflowlogs.blob.core.windows.net/?sv=2020-10-02&sig=x"
+
+	tests := []struct {
+		name    string
+		sasUrl  string
+		wantErr string
+	}{
+		{"ContainerReadList", container + "&sp=rl&se=2022-08-10T00:00:00Z", ""},
+		{"ContainerWithMorePermissions", container + "&sp=racwdl&se=2022-08-10", ""},
+		{"ContainerWithoutList", container + "&sp=r&se=2022-08-10T00:00:00Z", "read (r) and list (l)"},
+		{"ContainerWithoutRead", container + "&sp=l&se=2022-08-10T00:00:00Z", "read (r) and list (l)"},
+		{"ContainerExpired", container + "&sp=rl&se=2022-08-09T09:59:00Z", "expired"},
+		{"ContainerExpiringNow", container + "&sp=rl&se=2022-08-09T10:00Z", "expired"},
+		{"ContainerStoredAccessPolicy", container + "&si=flowlogs-read", ""},
+		{"AccountBlobContainersAndObjects", account + "&ss=b&srt=co&sp=rl&se=2022-08-10T00:00:00Z", ""},
+		{"AccountEveryService", account + "&ss=bfqt&srt=sco&sp=rwdlacup&se=2022-08-10T00:00:00Z", ""},
+		{"AccountWithoutBlobService", account + "&ss=q&srt=co&sp=rl&se=2022-08-10T00:00:00Z", "blob service"},
+		{"AccountWithoutObjects", account + "&ss=b&srt=sc&sp=rl&se=2022-08-10T00:00:00Z", "container (c) and object (o)"},
+		{"AccountWithoutContainers", account + "&ss=b&srt=o&sp=rl&se=2022-08-10T00:00:00Z", "container (c) and object (o)"},
+		{"AccountExpired", account + "&ss=b&srt=co&sp=rl&se=2022-08-01", "expired"},
+		{"InvalidExpiry", container + "&sp=rl&se=tomorrow", "invalid sas expiry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSasToken(tt.sasUrl, now)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
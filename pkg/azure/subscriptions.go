@@ -0,0 +1,106 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const subscriptionsApiVersion = "2022-01-01"
+
+// subscription states that can't be read from
+var disabledSubscriptionStates = []string{"Disabled", "Deleted"}
+
+// GetSubscriptions lists the IDs of the enabled subscriptions the credential can access, following
+// every page of results.  When tenantId is set only subscriptions in that tenant are returned.  Error
+// responses are returned as *azcore.ResponseError.
+func GetSubscriptions(ctx context.Context, cred *Credential, tenantId string) ([]string, error) {
+	pl, err := armruntime.NewPipeline("nsgpeek", "v0", cred, runtime.PipelineOptions{}, subscriptionsClientOptions(cred.Cloud))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subscriptions pipeline: %w", err)
+	}
+
+	return listSubscriptions(ctx, pl, cred.Cloud.resourceManagerEndpoint(), tenantId)
+}
+
+// listSubscriptions follows every page of the subscriptions list at the resource manager endpoint.
+func listSubscriptions(ctx context.Context, pl runtime.Pipeline, endpoint string, tenantId string) ([]string, error) {
+	next := runtime.JoinPaths(endpoint, "/subscriptions") + "?api-version=" + subscriptionsApiVersion
+	subs := make([]string, 0)
+
+	for next != "" {
+		page, err := getSubscriptionPage(ctx, pl, next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+		}
+
+		for _, s := range page.Value {
+			if includeSubscription(s, tenantId) {
+				subs = append(subs, s.SubscriptionId)
+			}
+		}
+
+		next = page.NextLink
+	}
+
+	return subs, nil
+}
+
+func getSubscriptionPage(ctx context.Context, pl runtime.Pipeline, url string) (*subscriptionList, error) {
+	req, err := runtime.NewRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := pl.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	var page subscriptionList
+	if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+		return nil, fmt.Errorf("failed to deserialise subscription response: %w", err)
+	}
+
+	return &page, nil
+}
+
+func includeSubscription(s subscription, tenantId string) bool {
+	if tenantId != "" && !strings.EqualFold(s.TenantId, tenantId) {
+		return false
+	}
+
+	for _, state := range disabledSubscriptionStates {
+		if strings.EqualFold(s.State, state) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type subscriptionList struct {
+	Value    []subscription `json:"value"`
+	NextLink string         `json:"nextLink"`
+}
+
+type subscription struct {
+	SubscriptionId string `json:"subscriptionId"`
+	TenantId       string `json:"tenantId"`
+	State          string `json:"state"`
+}
+
+func subscriptionsClientOptions(c Cloud) *arm.ClientOptions {
+	opts := c.armClientOptions()
+	opts.DisableRPRegistration = true
+	return opts
+}
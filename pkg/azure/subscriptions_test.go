@@ -0,0 +1,130 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const testTenantId = "11111111-1111-1111-1111-111111111111"
+
+func TestIncludeSubscription(t *testing.T) {
+	tests := []struct {
+		name     string
+		sub      subscription
+		tenantId string
+		want     bool
+	}{
+		{"Enabled", subscription{TenantId: testTenantId, State: "Enabled"}, "", true},
+		{"Warned", subscription{TenantId: testTenantId, State: "Warned"}, "", true},
+		{"PastDue", subscription{TenantId: testTenantId, State: "PastDue"}, "", true},
+		{"Disabled", subscription{TenantId: testTenantId, State: "Disabled"}, "", false},
+		{"Deleted", subscription{TenantId: testTenantId, State: "Deleted"}, "", false},
+		{"StateDiffersInCase", subscription{TenantId: testTenantId, State: "disabled"}, "", false},
+		{"InTenant", subscription{TenantId: testTenantId, State: "Enabled"}, testTenantId, true},
+		{"TenantDiffersInCase", subscription{TenantId: "AAAAAAAA-1111-1111-1111-111111111111", State: "Enabled"}, "aaaaaaaa-1111-1111-1111-111111111111", true},
+		{"InOtherTenant", subscription{TenantId: "22222222-2222-2222-2222-222222222222", State: "Enabled"}, testTenantId, false},
+		{"DisabledInTenant", subscription{TenantId: testTenantId, State: "Disabled"}, testTenantId, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := includeSubscription(tt.sub, tt.tenantId); got != tt.want {
+				t.Errorf("unexpected result. want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// subscriptionServer serves pages of subscriptions, linking each page to the next.
+func subscriptionServer(t *testing.T, pages [][]subscription) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/subscriptions" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var page int
+		if p := r.URL.Query().Get("page"); p != "" {
+			var err error
+			if page, err = strconv.Atoi(p); err != nil || page >= len(pages) {
+				http.Error(w, `{"error":{"code":"InvalidPage","message":"no such page"}}`, http.StatusBadRequest)
+				return
+			}
+		}
+
+		list := subscriptionList{Value: pages[page]}
+		if page < len(pages)-1 {
+			list.NextLink = fmt.Sprintf("%v/subscriptions?api-version=%v&page=%v", server.URL, subscriptionsApiVersion, page+1)
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestListSubscriptions(t *testing.T) {
+	pl := runtime.NewPipeline("nsgpeek", "v0", runtime.PipelineOptions{}, &azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}})
+
+	t.Run("FollowsEveryPage", func(t *testing.T) {
+		server := subscriptionServer(t, [][]subscription{
+			{{SubscriptionId: "sub-1", TenantId: testTenantId, State: "Enabled"}, {SubscriptionId: "sub-2", TenantId: testTenantId, State: "Disabled"}},
+			{},
+			{{SubscriptionId: "sub-3", TenantId: "22222222-2222-2222-2222-222222222222", State: "Enabled"}},
+			{{SubscriptionId: "sub-4", TenantId: testTenantId, State: "Warned"}},
+		})
+
+		got, err := listSubscriptions(context.Background(), pl, server.URL, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := []string{"sub-1", "sub-3", "sub-4"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected subscriptions. want: %v, got: %v", want, got)
+		}
+
+		got, err = listSubscriptions(context.Background(), pl, server.URL, testTenantId)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := []string{"sub-1", "sub-4"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected subscriptions in tenant. want: %v, got: %v", want, got)
+		}
+	})
+
+	t.Run("NoSubscriptions", func(t *testing.T) {
+		server := subscriptionServer(t, [][]subscription{{}})
+
+		got, err := listSubscriptions(context.Background(), pl, server.URL, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("expected no subscriptions, got %v", got)
+		}
+	})
+
+	t.Run("ReturnsResponseErrors", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error":{"code":"AuthorizationFailed","message":"denied"}}`, http.StatusForbidden)
+		}))
+		defer server.Close()
+
+		_, err := listSubscriptions(context.Background(), pl, server.URL, "")
+
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusForbidden {
+			t.Errorf("expected a forbidden response error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/AzureAD/microsoft-authentication-library-for-go/apps/confidential"
+)
+
+const defaultAuthorityHost = "https://login.microsoftonline.com/"
+
+var errWorkloadIdentityNotConfigured = errors.New("workload identity requires AZURE_TENANT_ID, AZURE_CLIENT_ID and AZURE_FEDERATED_TOKEN_FILE to be set")
+
+// workloadIdentityCredential exchanges the federated token that Kubernetes workload identity writes to
+// AZURE_FEDERATED_TOKEN_FILE for an Entra ID token.  The file is read whenever a new token is needed
+// because the token in it is rotated, and the client is kept so tokens are served from its cache.
+type workloadIdentityCredential struct {
+	tokenFile string
+	client    confidential.Client
+}
+
+func newWorkloadIdentityCredential(tenantId string, clientId string, c Cloud) (*workloadIdentityCredential, error) {
+	if tenantId == "" {
+		tenantId = os.Getenv("AZURE_TENANT_ID")
+	}
+	if clientId == "" {
+		clientId = os.Getenv("AZURE_CLIENT_ID")
+	}
+
+	tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	if tenantId == "" || clientId == "" || tokenFile == "" {
+		return nil, errWorkloadIdentityNotConfigured
+	}
+
+	// like the SDK's credentials, a chosen cloud takes precedence over AZURE_AUTHORITY_HOST
+	host := c.Configuration.ActiveDirectoryAuthorityHost
+	if host == "" {
+		host = os.Getenv("AZURE_AUTHORITY_HOST")
+	}
+	if host == "" {
+		host = defaultAuthorityHost
+	}
+
+	w := workloadIdentityCredential{tokenFile: tokenFile}
+	cred := confidential.NewCredFromAssertionCallback(w.assertion)
+
+	client, err := confidential.New(clientId, cred, confidential.WithAuthority(strings.TrimSuffix(host, "/")+"/"+tenantId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create confidential client: %w", err)
+	}
+	w.client = client
+
+	return &w, nil
+}
+
+func (w *workloadIdentityCredential) assertion(context.Context, confidential.AssertionRequestOptions) (string, error) {
+	assertion, err := os.ReadFile(w.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read federated token file: %w", err)
+	}
+	return strings.TrimSpace(string(assertion)), nil
+}
+
+func (w *workloadIdentityCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	res, err := w.client.AcquireTokenSilent(ctx, opts.Scopes)
+	if err != nil {
+		res, err = w.client.AcquireTokenByCredential(ctx, opts.Scopes)
+	}
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to acquire token with workload identity: %w", err)
+	}
+
+	return azcore.AccessToken{Token: res.AccessToken, ExpiresOn: res.ExpiresOn}, nil
+}
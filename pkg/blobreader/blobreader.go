@@ -1,24 +1,96 @@
 package blobreader
 
 import (
+	"context"
+	"time"
+
 	"github.com/tmeadon/nsgpeek/pkg/azure"
 )
 
 type Blob interface {
-	GetBlocks() ([]azure.BlobBlock, error)
-	ReadBlock(block *azure.BlobBlock, blockIndex int64) ([]byte, error)
+	GetBlocks(ctx context.Context) ([]azure.BlobBlock, error)
+	ReadBlock(ctx context.Context, block *azure.BlobBlock, blockIndex int64) ([]byte, error)
 }
 
+// DefaultDrainGrace is how long a stopped stream keeps reading a blob after it last changed.  Azure can
+// commit the last records of an hour a few minutes after the next hour's blob appears.
+const DefaultDrainGrace = time.Minute * 5
+
 type BlobReader struct {
-	blob  Blob
-	outCh chan ([][]byte)
-	errCh chan error
+	blob       Blob
+	outCh      chan ([][]byte)
+	errCh      chan error
+	posCh      chan<- Position
+	key        string
+	drainGrace time.Duration
+}
+
+// Position is how far through a blob a reader has sent data, the offset of the first block not yet sent.
+type Position struct {
+	Key    string
+	Offset int64
 }
 
 func NewBlobReader(blob Blob, outCh chan ([][]byte), errCh chan (error)) *BlobReader {
 	return &BlobReader{
-		blob:  blob,
-		outCh: outCh,
-		errCh: errCh,
+		blob:       blob,
+		outCh:      outCh,
+		errCh:      errCh,
+		drainGrace: DefaultDrainGrace,
+	}
+}
+
+// DrainFor sets how long a stream stopped through its stop channel carries on reading the blob once no
+// new blocks have been found in it.
+func (br *BlobReader) DrainFor(grace time.Duration) {
+	br.drainGrace = grace
+}
+
+// ReportPositions makes the reader send its position in the blob, tagged with key, to ch whenever it
+// changes.  A position is only sent once the data before it has been received from the output channel.
+func (br *BlobReader) ReportPositions(key string, ch chan<- Position) {
+	br.key = key
+	br.posCh = ch
+}
+
+// sendPosition sends the reader's position if it is reporting them, returning false if ctx is cancelled
+// first.
+func (br *BlobReader) sendPosition(ctx context.Context, offset int64) bool {
+	if br.posCh == nil {
+		return true
+	}
+
+	select {
+	case br.posCh <- Position{br.key, offset}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sendErr sends err to the reader's error channel unless ctx is cancelled first, in which case err is
+// likely caused by the cancellation.
+func (br *BlobReader) sendErr(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	select {
+	case br.errCh <- err:
+	case <-ctx.Done():
+	}
+}
+
+// sendData sends data to the reader's output channel, returning false if ctx is cancelled first.
+func (br *BlobReader) sendData(ctx context.Context, data [][]byte) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	select {
+	case br.outCh <- data:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
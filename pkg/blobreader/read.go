@@ -1,13 +1,16 @@
 package blobreader
 
 import (
+	"context"
 	"fmt"
 )
 
-func (br *BlobReader) Read(doneCh chan bool) {
-	blocks, err := br.blob.GetBlocks()
+// Read sends every data block in the blob to the reader's output channel and signals doneCh when
+// finished.  Reading stops without signalling doneCh if ctx is cancelled.
+func (br *BlobReader) Read(ctx context.Context, doneCh chan bool) {
+	blocks, err := br.blob.GetBlocks(ctx)
 	if err != nil {
-		br.errCh <- fmt.Errorf("failed to get block list: %w", err)
+		br.sendErr(ctx, fmt.Errorf("failed to get block list: %w", err))
 		return
 	}
 
@@ -15,18 +18,37 @@ func (br *BlobReader) Read(doneCh chan bool) {
 
 	// iterate through the blocks, skipping the first and the last
 	for i := 0; i < len(blocks); i++ {
-		d, err := br.blob.ReadBlock(&blocks[i], index)
+		d, err := br.blob.ReadBlock(ctx, &blocks[i], index)
 		if err != nil {
-			br.errCh <- err
+			br.sendErr(ctx, err)
 			return
 		}
 
 		if i != 0 && i != (len(blocks)-1) {
-			br.outCh <- [][]byte{d}
+			if !br.sendData(ctx, [][]byte{d}) {
+				return
+			}
 		}
 
 		index = index + blocks[i].Size
 	}
 
-	doneCh <- true
+	select {
+	case doneCh <- true:
+	case <-ctx.Done():
+	}
+}
+
+// ReadFrom sends the data blocks after offset, as returned in a Position, to the reader's output channel
+// and signals doneCh when finished.  It is used to catch up on a blob that is no longer being written.
+func (br *BlobReader) ReadFrom(ctx context.Context, offset int64, doneCh chan bool) {
+	if _, err := br.readNewBlocks(ctx, offset); err != nil {
+		br.sendErr(ctx, fmt.Errorf("failed to read blob from offset %v: %w", offset, err))
+		return
+	}
+
+	select {
+	case doneCh <- true:
+	case <-ctx.Done():
+	}
 }
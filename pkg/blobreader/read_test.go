@@ -1,6 +1,7 @@
 package blobreader
 
 import (
+	"context"
 	"reflect"
 	"testing"
 	"time"
@@ -28,7 +29,7 @@ func TestRead(t *testing.T) {
 
 	t.Run("AllBlocksSentOneByOne", func(t *testing.T) {
 		setup()
-		go testBlobReader.Read(doneCh)
+		go testBlobReader.Read(context.Background(), doneCh)
 		received := make([][]byte, 0)
 
 	read:
@@ -55,7 +56,7 @@ func TestRead(t *testing.T) {
 
 	t.Run("AllBlocksGetRead", func(t *testing.T) {
 		setup()
-		go testBlobReader.Read(doneCh)
+		go testBlobReader.Read(context.Background(), doneCh)
 
 	read:
 		for {
@@ -74,4 +75,24 @@ func TestRead(t *testing.T) {
 			t.Errorf("unexpected set of read blocks, expected %v, got %v", blob.Blocks, blob.BlocksRead)
 		}
 	})
+
+	t.Run("StopsWhenContextCancelled", func(t *testing.T) {
+		setup()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		stopped := make(chan bool)
+		go func() {
+			testBlobReader.Read(ctx, doneCh)
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-outCh:
+			t.Error("data received after context was cancelled")
+		case <-time.After(time.Second * 5):
+			t.Error("timed out waiting for Read to stop")
+		}
+	})
 }
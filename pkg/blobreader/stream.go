@@ -1,39 +1,90 @@
 package blobreader
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
 
-func (br *BlobReader) Stream(stopCh chan (bool), sleepDuration time.Duration) {
-	readPosition, err := br.skipToEnd()
+// Stream polls the blob for new blocks every sleepDuration and sends them to the reader's output
+// channel until true is received on stopCh or ctx is cancelled.  When stopped through stopCh the reader
+// drains the blob first, carrying on polling until no new blocks have been found for the drain grace
+// period set with DrainFor.
+func (br *BlobReader) Stream(ctx context.Context, stopCh chan (bool), sleepDuration time.Duration) {
+	readPosition, err := br.skipToEnd(ctx)
 	if err != nil {
-		br.errCh <- fmt.Errorf("failed to read to end of blob: %w", err)
+		br.sendErr(ctx, fmt.Errorf("failed to read to end of blob: %w", err))
 		return
 	}
 
-	stop := false
+	if !br.sendPosition(ctx, readPosition) {
+		return
+	}
+
+	br.StreamFrom(ctx, stopCh, readPosition, sleepDuration)
+}
+
+// StreamFrom is like Stream but starts at offset, as returned in a Position, instead of the end of the
+// blob.  Blocks already written after offset are sent straight away.
+func (br *BlobReader) StreamFrom(ctx context.Context, stopCh chan (bool), offset int64, sleepDuration time.Duration) {
+	readPosition, err := br.readNewBlocks(ctx, offset)
+	if err != nil {
+		br.sendErr(ctx, err)
+		return
+	}
 
 	for {
 		select {
-		case stop = <-stopCh:
+		case stop := <-stopCh:
+			if !stop {
+				continue
+			}
+			br.drain(ctx, readPosition, sleepDuration)
+			return
+		case <-ctx.Done():
+			return
 		case <-time.After(sleepDuration):
-			pos, err := br.readNewBlocks(int64(readPosition))
+			pos, err := br.readNewBlocks(ctx, readPosition)
 			if err != nil {
-				br.errCh <- err
+				br.sendErr(ctx, err)
 				return
 			}
 			readPosition = pos
 		}
+	}
+}
+
+// drain reads the blob straight away and then every sleepDuration until it has gone the drain grace period
+// without changing.
+func (br *BlobReader) drain(ctx context.Context, readPosition int64, sleepDuration time.Duration) {
+	changed := time.Now()
+
+	for {
+		pos, err := br.readNewBlocks(ctx, readPosition)
+		if err != nil {
+			br.sendErr(ctx, fmt.Errorf("failed to drain blob: %w", err))
+			return
+		}
+
+		if pos != readPosition {
+			readPosition = pos
+			changed = time.Now()
+		}
 
-		if stop {
-			break
+		if time.Since(changed) >= br.drainGrace {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sleepDuration):
 		}
 	}
 }
 
-func (br *BlobReader) skipToEnd() (int64, error) {
-	blocks, err := br.blob.GetBlocks()
+func (br *BlobReader) skipToEnd(ctx context.Context) (int64, error) {
+	blocks, err := br.blob.GetBlocks(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -47,8 +98,8 @@ func (br *BlobReader) skipToEnd() (int64, error) {
 	return index, nil
 }
 
-func (br *BlobReader) readNewBlocks(offset int64) (int64, error) {
-	blocks, err := br.blob.GetBlocks()
+func (br *BlobReader) readNewBlocks(ctx context.Context, offset int64) (int64, error) {
+	blocks, err := br.blob.GetBlocks(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -59,7 +110,7 @@ func (br *BlobReader) readNewBlocks(offset int64) (int64, error) {
 	// iterate through the blocks, skipping the first and the last
 	for i := 0; i < (len(blocks) - 1); i++ {
 		if index >= offset {
-			d, err := br.blob.ReadBlock(&blocks[i], index)
+			d, err := br.blob.ReadBlock(ctx, &blocks[i], index)
 			if err != nil {
 				return 0, err
 			}
@@ -72,8 +123,12 @@ func (br *BlobReader) readNewBlocks(offset int64) (int64, error) {
 		index = index + blocks[i].Size
 	}
 
-	if len(data) > 0 {
-		br.outCh <- data
+	if len(data) > 0 && !br.sendData(ctx, data) {
+		return 0, ctx.Err()
+	}
+
+	if index != offset && !br.sendPosition(ctx, index) {
+		return 0, ctx.Err()
 	}
 
 	return index, nil
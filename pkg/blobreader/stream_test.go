@@ -1,6 +1,7 @@
 package blobreader
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -28,7 +29,7 @@ func TestStream(t *testing.T) {
 
 	t.Run("DoesntSendExistingBlocks", func(t *testing.T) {
 		setup()
-		go testBlobReader.Stream(stopCh, time.Second)
+		go testBlobReader.Stream(context.Background(), stopCh, time.Second)
 		select {
 		case data := <-outCh:
 			if data != nil {
@@ -40,7 +41,7 @@ func TestStream(t *testing.T) {
 
 	t.Run("SendsNewBlocks", func(t *testing.T) {
 		setup()
-		go testBlobReader.Stream(stopCh, time.Second)
+		go testBlobReader.Stream(context.Background(), stopCh, time.Second)
 		time.Sleep(time.Second)
 
 		newBlocks := []azure.BlobBlock{{Name: "test1", Size: 123}, {Name: "test2", Size: 999}}
@@ -65,26 +66,114 @@ func TestStream(t *testing.T) {
 
 	t.Run("StopsCorrectly", func(t *testing.T) {
 		setup()
-		go testBlobReader.Stream(stopCh, time.Second)
+		testBlobReader.DrainFor(time.Millisecond * 200)
+		go testBlobReader.Stream(context.Background(), stopCh, time.Millisecond*100)
+
+		stopCh <- true
+		// let the drain find the blob unchanged for the grace period before writing again
+		time.Sleep(time.Millisecond * 500)
+
+		blob.AddBlocks([]azure.BlobBlock{{Name: "test123", Size: 999}})
+
+		select {
+		case <-outCh:
+			t.Error("data received when Stream should have stopped")
+		case <-time.After(time.Second * 1):
+		}
+	})
+
+	t.Run("DrainsBlocksWrittenBeforeStop", func(t *testing.T) {
+		setup()
+		posCh := make(chan Position)
+		testBlobReader.ReportPositions("key", posCh)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// poll too slowly for anything but the drain to find the new blocks
+		go testBlobReader.Stream(ctx, stopCh, time.Hour)
+
+		select {
+		case <-posCh:
+		case <-time.After(time.Second * 5):
+			t.Fatal("stream didn't skip to the end of the blob")
+		}
+
+		newBlocks := []azure.BlobBlock{{Name: "test1", Size: 123}, {Name: "test2", Size: 999}}
+		blob.AddBlocks(newBlocks)
+		stopCh <- true
+
+		select {
+		case data := <-outCh:
+			if len(data) != len(newBlocks) {
+				t.Fatalf("unexpected number of blocks drained. want: %v, got: %v", len(newBlocks), len(data))
+			}
+			for i := range newBlocks {
+				if string(data[i]) != newBlocks[i].Name {
+					t.Errorf("stream drained the wrong data. expected %v, got %v", newBlocks[i].Name, string(data[i]))
+				}
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("stream didn't drain blocks written before it was stopped")
+		}
+
+		select {
+		case <-posCh:
+		case <-time.After(time.Second * 5):
+			t.Fatal("stream didn't report its position after draining")
+		}
+	})
+
+	t.Run("DrainsBlocksWrittenAfterStop", func(t *testing.T) {
+		setup()
+		posCh := make(chan Position)
+		testBlobReader.ReportPositions("key", posCh)
+		testBlobReader.DrainFor(time.Second * 5)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go testBlobReader.Stream(ctx, stopCh, time.Millisecond*100)
 
 		select {
-		case stopCh <- true:
-		default:
+		case <-posCh:
+		case <-time.After(time.Second * 5):
+			t.Fatal("stream didn't skip to the end of the blob")
 		}
 
+		stopCh <- true
+		// the blob's last blocks are committed after the stream has been told to stop
+		time.Sleep(time.Millisecond * 300)
+		blob.AddBlocks([]azure.BlobBlock{{Name: "late", Size: 123}})
+
+		select {
+		case data := <-outCh:
+			if len(data) != 1 || string(data[0]) != "late" {
+				t.Errorf("unexpected data drained. want: [late], got: %q", data)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("stream didn't drain blocks written after it was stopped")
+		}
+	})
+
+	t.Run("StopsWhenContextCancelled", func(t *testing.T) {
+		setup()
+		ctx, cancel := context.WithCancel(context.Background())
+		go testBlobReader.Stream(ctx, stopCh, time.Second)
+		cancel()
+
 		blob.AddBlocks([]azure.BlobBlock{{Name: "test123", Size: 999}})
 
 		select {
 		case <-outCh:
 			t.Error("data received when Stream should have stopped")
-		case <-time.After(time.Second * 1):
+		case <-time.After(time.Second * 2):
 		}
 	})
 
 	t.Run("SendsErrorsCorrectly", func(t *testing.T) {
 		setup()
 		br := NewBlobReader(erroringBlob, outCh, errCh)
-		go br.Stream(stopCh, time.Second)
+		go br.Stream(context.Background(), stopCh, time.Second)
 
 		select {
 		case err := <-errCh:
@@ -96,9 +185,47 @@ func TestStream(t *testing.T) {
 		}
 	})
 
+	t.Run("StreamFromSendsBlocksAfterOffsetAndReportsPosition", func(t *testing.T) {
+		setup()
+		posCh := make(chan Position)
+		testBlobReader.ReportPositions("key", posCh)
+
+		// start after the first two blocks
+		offset := blob.Blocks[0].Size + blob.Blocks[1].Size
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go testBlobReader.StreamFrom(ctx, stopCh, offset, time.Millisecond*100)
+
+		select {
+		case data := <-outCh:
+			if len(data) != len(blob.Blocks)-3 {
+				t.Fatalf("unexpected number of blocks sent. want: %v, got: %v", len(blob.Blocks)-3, len(data))
+			}
+			if string(data[0]) != blob.Blocks[2].Name {
+				t.Errorf("unexpected first block. want: %v, got: %v", blob.Blocks[2].Name, string(data[0]))
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("stream didn't send blocks after offset")
+		}
+
+		var want int64
+		for _, b := range blob.Blocks[:len(blob.Blocks)-1] {
+			want += b.Size
+		}
+
+		select {
+		case pos := <-posCh:
+			if pos.Key != "key" || pos.Offset != want {
+				t.Errorf("unexpected position. want: %v, got: %+v", want, pos)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("stream didn't report its position")
+		}
+	})
+
 	t.Run("DoesNotSendDuplicateBlocks", func(t *testing.T) {
 		setup()
-		go testBlobReader.Stream(stopCh, time.Second)
+		go testBlobReader.Stream(context.Background(), stopCh, time.Second)
 		time.Sleep(time.Second)
 
 		waitForData := func() (received [][]byte) {
@@ -0,0 +1,115 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Checkpoint records how far through each blob being followed a stream has written, so that a
+// restarted stream can carry on where it left off.  It is saved to its file after every change.
+type Checkpoint struct {
+	path  string
+	Blobs map[string]BlobPosition `json:"blobs"`
+}
+
+// BlobPosition is the offset of the first byte not yet written from a blob.
+type BlobPosition struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+}
+
+// Load reads the checkpoint saved at path, returning an empty checkpoint if the file doesn't exist yet.
+func Load(path string) (*Checkpoint, error) {
+	c := &Checkpoint{path: path, Blobs: make(map[string]BlobPosition)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %v: %w", path, err)
+	}
+
+	if c.Blobs == nil {
+		c.Blobs = make(map[string]BlobPosition)
+	}
+
+	return c, nil
+}
+
+// Key returns the key a blob is recorded under, its URL without the query string so that SAS tokens
+// aren't written to the checkpoint and a new token doesn't lose the blob's position.
+func Key(blobUrl string) string {
+	u, err := url.Parse(blobUrl)
+	if err != nil {
+		return blobUrl
+	}
+
+	u.RawQuery = ""
+	return u.String()
+}
+
+// Offset returns the recorded offset of a blob.
+func (c *Checkpoint) Offset(key string) (int64, bool) {
+	p, ok := c.Blobs[key]
+	return p.Offset, ok
+}
+
+// Set records the offset of a blob and saves the checkpoint.
+func (c *Checkpoint) Set(key string, path string, offset int64) error {
+	if p, ok := c.Blobs[key]; ok && p.Offset == offset {
+		return nil
+	}
+
+	c.Blobs[key] = BlobPosition{Path: path, Offset: offset}
+	return c.save()
+}
+
+// Remove stops recording a blob that is no longer being followed and saves the checkpoint.
+func (c *Checkpoint) Remove(key string) error {
+	if _, ok := c.Blobs[key]; !ok {
+		return nil
+	}
+
+	delete(c.Blobs, key)
+	return c.save()
+}
+
+// save writes the checkpoint to a temporary file and renames it over the old one, so a crash part way
+// through never leaves a truncated checkpoint behind.
+func (c *Checkpoint) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	const blobUrl = "https://stg.blob.core.windows.net/insights-logs-networksecuritygroupflowevent/resourceId=/NSG/y=2022/m=08/d=09/h=10/m=00/macAddress=000D3AD488D1/PT1H.json"
+
+	t.Run("LoadsEmptyCheckpointWhenFileMissing", func(t *testing.T) {
+		c, err := Load(filepath.Join(t.TempDir(), "checkpoint.json"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(c.Blobs) != 0 {
+			t.Errorf("expected no blobs, got %v", c.Blobs)
+		}
+	})
+
+	t.Run("SavesPositionsAcrossLoads", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+		c, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Set(blobUrl, "PT1H.json", 1234); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := c.Set(blobUrl+"2", "PT1H.json", 10); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := c.Remove(blobUrl + "2"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if offset, ok := loaded.Offset(blobUrl); !ok || offset != 1234 {
+			t.Errorf("unexpected offset. want: 1234, got: %v (found: %v)", offset, ok)
+		}
+		if _, ok := loaded.Offset(blobUrl + "2"); ok {
+			t.Error("removed blob found in checkpoint")
+		}
+
+		// only the checkpoint should be left, not temporary files
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+			t.Errorf("unexpected files next to checkpoint: %v", entries)
+		}
+	})
+
+	t.Run("RejectsInvalidFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "checkpoint.json")
+		if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Load(path); err == nil {
+			t.Error("expected an error loading an invalid checkpoint")
+		}
+	})
+
+	t.Run("KeyOmitsSasToken", func(t *testing.T) {
+		if got := Key(blobUrl + "?sv=2020-10-02&sig=secret"); got != blobUrl {
+			t.Errorf("unexpected key. want: %v, got: %v", blobUrl, got)
+		}
+	})
+}
@@ -1,80 +1,297 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/tmeadon/nsgpeek/pkg/azure"
 	"github.com/tmeadon/nsgpeek/pkg/flowwriter"
+	"github.com/tmeadon/nsgpeek/pkg/logblobfinder"
 )
 
 var (
 	cli struct {
-		Debug bool `help:"Enable debug mode"`
+		Debug    bool   `help:"Enable debug mode"`
+		Auth     string `enum:"cli,environment,managed-identity,workload-identity,device-code,default" default:"cli" help:"(Optional) How to authenticate to Azure, one of: ${enum}"`
+		TenantId string `help:"(Optional) Tenant to authenticate in and search for subscriptions"`
+		ClientId string `help:"(Optional) Client ID of a user-assigned managed identity, or of the application used for workload identity and device code authentication"`
 
-		Stream StreamCmd `cmd:"" help:"Stream NSG flow logs"`
-		Search SearchCmd `cmd:"" help:"Search historical NSG flow logs"`
-	}
+		Cloud           string `enum:"public,usgov,china,custom" default:"public" help:"(Optional) Azure cloud to connect to, one of: ${enum}"`
+		AuthorityHost   string `help:"(Optional) Entra ID authority host for a custom cloud, defaults to the public cloud's"`
+		ArmEndpoint     string `help:"(Optional) Resource manager endpoint for a custom cloud"`
+		StorageEndpoint string `help:"(Optional) Blob endpoint for a custom cloud with {account} in place of the storage account name, e.g. https://{account}.blob.local.azurestack.external/. Without {account} the account name is added to the path, e.g. http://127.0.0.1:10000/ for Azurite"`
 
-	cred *azure.Credential
-	subs []string
+		Stream   StreamCmd   `cmd:"" help:"Stream NSG flow logs"`
+		Search   SearchCmd   `cmd:"" help:"Search historical NSG flow logs"`
+		Generate GenerateCmd `cmd:"" help:"Generate synthetic NSG flow logs for testing"`
+	}
 )
 
 type cliContext struct {
 	Debug bool
+	ctx   context.Context
 }
 
 type commonArgs struct {
-	NsgName   string `required:"" short:"n" help:"Name of the NSG to stream logs from"`
-	Quiet     bool   `short:"q" help:"(Optional) Don't print to console"`
-	File      string `short:"f" help:"(Optional) File path to write logs to in CSV format"`
-	Overwrite bool   `help:"(Optional) Overwrite file if already exists"`
+	NsgName       []string      `required:"" short:"n" help:"Name, glob such as 'nsg-hub-*' or full resource ID of an NSG to read logs from, repeat to read from several at once"`
+	Subscription  []string      `short:"s" help:"(Optional) Subscription IDs to look for the NSG in, defaults to every subscription you can access"`
+	ResourceGroup string        `short:"g" help:"(Optional) Resource group containing the NSG"`
+	FlowLog       string        `help:"(Optional) Name of the flow log to read, defaults to every enabled flow log on the NSG"`
+	Quiet         bool          `short:"q" help:"(Optional) Don't print to console"`
+	File          string        `short:"f" help:"(Optional) File path to write logs to"`
+	Overwrite     bool          `help:"(Optional) Overwrite file if already exists"`
+	Format        string        `enum:"table,jsonl" default:"table" help:"(Optional) Console output format, one of: ${enum}"`
+	FileFormat    string        `enum:"csv,jsonl" default:"csv" help:"(Optional) File output format, one of: ${enum}"`
+	Filter        string        `short:"F" help:"(Optional) Only output flows matching this expression, e.g. 'src_addr in 10.0.0.0/8 and dst_port == 443 and decision == deny'"`
+	ConsoleFilter string        `help:"(Optional) Filter expression applied to console output only"`
+	FileFilter    string        `help:"(Optional) Filter expression applied to file output only"`
+	OnParseError  string        `enum:"skip,warn,fail" default:"warn" help:"(Optional) How to handle malformed flow tuples, one of: ${enum}"`
+	MaxAttempts   int           `default:"4" help:"(Optional) Maximum number of attempts for each storage request"`
+	RetryDelay    time.Duration `default:"1s" help:"(Optional) Delay before the first retry of a failed storage request, doubling after each attempt"`
+	SortWindow    time.Duration `help:"(Optional) Write flows once they are older than the newest flow read by this duration, e.g. 10m, instead of holding every flow in memory until the end of a batch. Search defaults to 1m, stream can't use it with --checkpoint"`
+	StorageAuth   string        `enum:"auto,token,key,sas" default:"auto" help:"(Optional) How to authenticate to flow log storage accounts, one of: ${enum}. Auto tries the SAS URL if given, then your token and then account keys"`
+	SasUrl        string        `help:"(Optional) SAS URL for the flow log storage account's blob service or its flow log container"`
+}
+
+var authModes = map[string]azure.AuthMode{
+	"cli":               azure.AuthAzureCli,
+	"environment":       azure.AuthEnvironment,
+	"managed-identity":  azure.AuthManagedIdentity,
+	"workload-identity": azure.AuthWorkloadIdentity,
+	"device-code":       azure.AuthDeviceCode,
+	"default":           azure.AuthDefault,
+}
+
+var clouds = map[string]azure.Cloud{
+	"public": azure.CloudPublic,
+	"usgov":  azure.CloudGovernment,
+	"china":  azure.CloudChina,
+}
+
+var storageAuthModes = map[string]azure.StorageAuthMode{
+	"auto":  azure.StorageAuthAuto,
+	"token": azure.StorageAuthToken,
+	"key":   azure.StorageAuthKey,
+	"sas":   azure.StorageAuthSas,
+}
+
+var parseErrorPolicies = map[string]flowwriter.ParseErrorPolicy{
+	"skip": flowwriter.SkipOnParseError,
+	"warn": flowwriter.WarnOnParseError,
+	"fail": flowwriter.FailOnParseError,
 }
 
 func Run() {
 	ctx := kong.Parse(&cli, kong.UsageOnError(), kong.Name("nsgpeek"))
-	log.Print("getting credential")
-	getCredential(ctx)
-	log.Print("getting subs")
-	getUserSubscriptions(ctx)
 
-	err := ctx.Run(&cliContext{Debug: cli.Debug})
+	// cancel everything in flight on ctrl-c so that commands can flush their output and exit cleanly
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := ctx.Run(&cliContext{Debug: cli.Debug, ctx: sigCtx})
 
 	ctx.FatalIfErrorf(err)
 }
 
-func getCredential(ctx *kong.Context) {
-	c, err := azure.GetCredential()
+func getCredential() (*azure.Credential, error) {
+	log.Print("getting credential")
+
+	cloud, err := getCloud()
 	if err != nil {
-		ctx.FatalIfErrorf(err)
+		return nil, err
 	}
 
-	cred = c
+	return azure.GetCredential(azure.CredentialOptions{
+		Mode:     authModes[cli.Auth],
+		TenantId: cli.TenantId,
+		ClientId: cli.ClientId,
+		Cloud:    cloud,
+	})
 }
 
-func getUserSubscriptions(ctx *kong.Context) {
-	s, err := azure.GetSubscriptions(cred)
+func getCloud() (azure.Cloud, error) {
+	if cli.Cloud == "custom" {
+		return azure.NewCustomCloud(cli.AuthorityHost, cli.ArmEndpoint, cli.StorageEndpoint)
+	}
+
+	if cli.AuthorityHost != "" || cli.ArmEndpoint != "" || cli.StorageEndpoint != "" {
+		return azure.Cloud{}, errors.New("--authority-host, --arm-endpoint and --storage-endpoint can only be used with --cloud custom")
+	}
+
+	return clouds[cli.Cloud], nil
+}
+
+// getLogBlobFinders finds the flow logs for the NSG in args, tests replace it to use fake storage.
+var getLogBlobFinders = newLogBlobFinders
+
+// newLogBlobFinders finds the flow logs for the NSGs in args, only listing the user's subscriptions when
+// no subscription is given and an NSG isn't identified by its resource ID.  Interactive users are
+// asked to choose when more than one NSG has a given name.
+func newLogBlobFinders(ctx context.Context, args commonArgs) ([]*logblobfinder.Finder, error) {
+	cred, err := getCredential()
 	if err != nil {
-		ctx.FatalIfErrorf(err)
+		return nil, err
 	}
 
-	subs = s
+	subs := args.Subscription
+
+	if len(subs) == 0 && !allNsgResourceIds(args.NsgName) {
+		log.Print("getting subs")
+
+		subs, err = azure.GetSubscriptions(ctx, cred, cli.TenantId)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(subs) == 0 {
+			return nil, errors.New("no enabled subscriptions found, use --tenant-id to choose the tenant containing the nsg or pass --subscription")
+		}
+	}
+
+	if args.StorageAuth == "sas" && args.SasUrl == "" {
+		return nil, errors.New("--sas-url is required when --storage-auth is sas")
+	}
+
+	storageOpts := azure.StorageOptions{
+		Mode:   storageAuthModes[args.StorageAuth],
+		SasUrl: args.SasUrl,
+	}
+
+	var finders []*logblobfinder.Finder
+	seen := make(map[string]bool)
+
+	for _, name := range args.NsgName {
+		found, err := findNsgLogBlobs(ctx, cred, subs, name, args.ResourceGroup, args.FlowLog, storageOpts)
+		if err != nil {
+			return nil, err
+		}
+
+		// the same nsg can be matched by more than one name or glob
+		for _, f := range found {
+			key := strings.ToLower(f.Nsg() + f.FlowLog.StorageId.String())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			reportFlowLog(f)
+			finders = append(finders, f)
+		}
+	}
+
+	return finders, nil
 }
 
-func initWriterGroup(args commonArgs) (*flowwriter.WriterGroup, error) {
-	consoleWriter := flowwriter.NewConsoleWriter(os.Stdout)
-	writers := flowwriter.NewWriterGroup(consoleWriter)
+// findNsgLogBlobs returns the finders for one NSG name, glob or resource ID.
+func findNsgLogBlobs(ctx context.Context, cred *azure.Credential, subs []string, nsgName string, resourceGroup string, flowLogName string, storageOpts azure.StorageOptions) ([]*logblobfinder.Finder, error) {
+	finders, err := logblobfinder.NewLogBlobFinders(subs, resourceGroup, nsgName, flowLogName, storageOpts, ctx, cred)
+
+	var ambiguous *azure.AmbiguousNsgError
+	if errors.As(err, &ambiguous) && isInteractive() {
+		nsgId, perr := promptForNsg(ambiguous)
+		if perr != nil {
+			return nil, perr
+		}
+		finders, err = logblobfinder.NewLogBlobFinders(subs, "", nsgId, flowLogName, storageOpts, ctx, cred)
+	}
 
-	if err := addCsvWriter(args.File, args.Overwrite, writers); err != nil {
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", nsgName, err)
+	}
+
+	return finders, nil
+}
+
+func allNsgResourceIds(names []string) bool {
+	for _, n := range names {
+		if !azure.IsNsgResourceId(n) {
+			return false
+		}
+	}
+	return true
+}
+
+func reportFlowLog(f *logblobfinder.Finder) {
+	fl := f.FlowLog
+
+	retention := "forever"
+	if fl.RetentionDays > 0 {
+		retention = fmt.Sprintf("%v days", fl.RetentionDays)
+	}
+
+	fmt.Fprintf(os.Stderr, "reading flow log '%v' of nsg %v (version %v, retained %v) from storage account %v\n", fl.Name, azure.NsgName(f.Nsg()), fl.Version, retention, fl.StorageId.Name)
+}
+
+func initWriterGroup(args commonArgs, filters ...flowwriter.Filter) (*flowwriter.WriterGroup, error) {
+	filter, err := parseFilters(args.Filter)
+	if err != nil {
+		return nil, err
+	}
+	consoleFilter, err := parseFilters(args.ConsoleFilter)
+	if err != nil {
+		return nil, err
+	}
+	fileFilter, err := parseFilters(args.FileFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	writers := flowwriter.NewWriterGroup()
+	writers.SetParseErrorPolicy(parseErrorPolicies[args.OnParseError])
+	writers.SetSortWindow(args.SortWindow)
+
+	for _, f := range append(filters, filter...) {
+		writers.AddFilter(f)
+	}
+
+	if !args.Quiet {
+		writers.AddWriter(newConsoleWriter(args.Format), consoleFilter...)
+	}
+
+	if err := addFileWriter(args.File, args.FileFormat, args.Overwrite, writers, fileFilter...); err != nil {
 		return nil, err
 	}
 
 	return writers, nil
 }
 
-func addCsvWriter(path string, overwrite bool, wg *flowwriter.WriterGroup) error {
+func parseFilters(expr string) ([]flowwriter.Filter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	f, err := flowwriter.ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return []flowwriter.Filter{f}, nil
+}
+
+func (args commonArgs) retryPolicy() azure.RetryPolicy {
+	return azure.RetryPolicy{
+		MaxAttempts: args.MaxAttempts,
+		BaseDelay:   args.RetryDelay,
+		MaxDelay:    azure.DefaultRetryPolicy.MaxDelay,
+	}
+}
+
+func newConsoleWriter(format string) flowwriter.FlowWriter {
+	if format == "jsonl" {
+		return flowwriter.NewJsonLinesWriter(os.Stdout)
+	}
+	return flowwriter.NewConsoleWriter(os.Stdout)
+}
+
+func addFileWriter(path string, format string, overwrite bool, wg *flowwriter.WriterGroup, filters ...flowwriter.Filter) error {
 	if path != "" {
 		if _, err := os.Stat(path); err == nil && !overwrite {
 			return fmt.Errorf("file already exists at path %v - add --overwrite or specify a different filepath, see command help for details", path)
@@ -85,12 +302,17 @@ func addCsvWriter(path string, overwrite bool, wg *flowwriter.WriterGroup) error
 			return fmt.Errorf("failed to create file %v: %w", path, err)
 		}
 
+		if format == "jsonl" {
+			wg.AddWriter(flowwriter.NewJsonLinesWriter(file), filters...)
+			return nil
+		}
+
 		csvWriter, err := flowwriter.NewCsvFileWriter(file)
 		if err != nil {
 			return fmt.Errorf("failed to create csv file writer: %w", err)
 		}
 
-		wg.AddWriter(csvWriter)
+		wg.AddWriter(csvWriter, filters...)
 	}
 	return nil
 }
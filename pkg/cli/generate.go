@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/azure"
+	"github.com/tmeadon/nsgpeek/pkg/flowgen"
+)
+
+type GenerateCmd struct {
+	NsgId        string    `required:"" help:"Resource ID of the NSG the logs appear to come from"`
+	Start        time.Time `required:"" help:"Start time (UTC) of the generated logs in format '2006-01-02 15:04:05'" format:"2006-01-02 15:04:05"`
+	End          time.Time `required:"" help:"End time (UTC) of the generated logs in format '2006-01-02 15:04:05'" format:"2006-01-02 15:04:05"`
+	OutDir       string    `xor:"out" help:"Directory to write the flow log blobs to, laid out as in the flow log container"`
+	ContainerUrl string    `xor:"out" help:"SAS URL of a blob container to write the flow log blobs to"`
+	Version      int       `default:"2" help:"(Optional) Flow log format version, 1 or 2"`
+	Nics         int       `default:"1" help:"(Optional) Number of NICs to generate a blob for each hour"`
+	Rate         int       `default:"60" help:"(Optional) Flows logged per minute for each NIC"`
+	DenyRatio    float64   `default:"0.1" help:"(Optional) Fraction of flows that are denied"`
+	Rules        []string  `default:"UserRule_AllowHttps,DefaultRule_AllowVnetInBound" help:"(Optional) Rules that allow flows"`
+	DenyRule     string    `default:"DefaultRule_DenyAllInBound" help:"(Optional) Rule that denies flows"`
+	SrcPool      []string  `default:"10.0.0.0/24" help:"(Optional) Prefixes source addresses are picked from"`
+	DstPool      []string  `default:"10.1.0.0/24" help:"(Optional) Prefixes destination addresses are picked from"`
+	DstPorts     []int     `default:"22,80,443,3389" help:"(Optional) Destination ports flows are picked from"`
+	Seed         int64     `help:"(Optional) Seed for reproducible output, defaults to the current time"`
+}
+
+func (g *GenerateCmd) Run(ctx *cliContext) error {
+	if g.OutDir == "" && g.ContainerUrl == "" {
+		return errors.New("one of --out-dir or --container-url is required")
+	}
+
+	srcPool, err := parsePrefixes(g.SrcPool)
+	if err != nil {
+		return err
+	}
+	dstPool, err := parsePrefixes(g.DstPool)
+	if err != nil {
+		return err
+	}
+
+	if g.Nics < 1 {
+		return fmt.Errorf("nics must be at least 1, got %v", g.Nics)
+	}
+
+	if !g.Start.Before(g.End) {
+		return fmt.Errorf("start must be before end, got %v and %v", g.Start, g.End)
+	}
+
+	seed := g.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	gen, err := flowgen.NewGenerator(flowgen.Config{
+		NsgId:      g.NsgId,
+		Macs:       flowgen.RandomMacs(g.Nics, seed),
+		Version:    g.Version,
+		Rate:       g.Rate,
+		DenyRatio:  g.DenyRatio,
+		AllowRules: g.Rules,
+		DenyRule:   g.DenyRule,
+		SrcPool:    srcPool,
+		DstPool:    dstPool,
+		DstPorts:   g.DstPorts,
+	}, seed)
+	if err != nil {
+		return err
+	}
+
+	write := func(b flowgen.Blob) error { return flowgen.WriteDir(g.OutDir, b) }
+
+	if g.ContainerUrl != "" {
+		w, err := azure.NewContainerWriter(g.ContainerUrl)
+		if err != nil {
+			return err
+		}
+		write = func(b flowgen.Blob) error { return w.WriteBlob(ctx.ctx, b.Path, b.Blocks) }
+	}
+
+	count := 0
+	err = gen.Generate(g.Start, g.End, func(b flowgen.Blob) error {
+		if err := ctx.ctx.Err(); err != nil {
+			return err
+		}
+		count++
+		return write(b)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate flow logs: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "wrote %v blobs\n", count)
+	return nil
+}
+
+func parsePrefixes(prefixes []string) ([]netip.Prefix, error) {
+	var pool []netip.Prefix
+
+	for _, p := range prefixes {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address prefix '%v': %w", p, err)
+		}
+		pool = append(pool, prefix)
+	}
+
+	return pool, nil
+}
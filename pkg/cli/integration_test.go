@@ -0,0 +1,806 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/tmeadon/nsgpeek/internal/nsgpeektest"
+	"github.com/tmeadon/nsgpeek/pkg/azure"
+	"github.com/tmeadon/nsgpeek/pkg/checkpoint"
+	"github.com/tmeadon/nsgpeek/pkg/logblobfinder"
+)
+
+// These tests drive the commands against nsgpeek's real storage client, pointed at an in-process fake
+// of the Blob REST API in place of the flow log storage account found through resource manager.
+
+const (
+	testAccount = "devstoreaccount1"
+	testNsgId   = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-test/providers/Microsoft.Network/networkSecurityGroups/nsg-test"
+	testMac     = "000D3AD488D1"
+)
+
+// testNsgIdFor returns the resource ID of an NSG in the same resource group as the test NSG.
+func testNsgIdFor(name string) string {
+	return strings.TrimSuffix(testNsgId, "nsg-test") + name
+}
+
+// useFakeStorage makes the commands read flow logs from server, restoring the real finders and polling
+// intervals when the test ends.
+func useFakeStorage(t *testing.T, server *nsgpeektest.BlobServer) {
+	cloud, err := azure.NewCustomCloud("", "https://management.local/", server.BlobEndpoint())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stgId, err := arm.ParseResourceID("/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-test/providers/Microsoft.Storage/storageAccounts/" + testAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fl := azure.FlowLog{Name: "fl-test", StorageId: &azure.ResourceId{ResourceID: *stgId}, Enabled: true, Version: 2}
+	opts := azure.StorageOptions{Mode: azure.StorageAuthSas, SasUrl: server.SasUrl(testAccount)}
+
+	getLogBlobFinders = func(ctx context.Context, args commonArgs) ([]*logblobfinder.Finder, error) {
+		getter, err := azure.NewAzureStorageBlobGetter(ctx, &azure.Credential{Cloud: cloud}, fl.StorageId, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		var finders []*logblobfinder.Finder
+		for _, name := range args.NsgName {
+			finders = append(finders, logblobfinder.NewStorageLogBlobFinder(getter, testNsgIdFor(name), fl))
+		}
+		return finders, nil
+	}
+
+	pollInterval, latestInterval, drainGrace := streamPollInterval, findLatestInterval, streamDrainGrace
+	streamPollInterval, findLatestInterval, streamDrainGrace = time.Millisecond*100, time.Millisecond*200, time.Second
+
+	t.Cleanup(func() {
+		getLogBlobFinders = newLogBlobFinders
+		streamPollInterval, findLatestInterval, streamDrainGrace = pollInterval, latestInterval, drainGrace
+	})
+}
+
+func testArgs(t *testing.T) commonArgs {
+	return commonArgs{
+		NsgName:      []string{"nsg-test"},
+		Quiet:        true,
+		File:         filepath.Join(t.TempDir(), "flows.jsonl"),
+		FileFormat:   "jsonl",
+		OnParseError: "fail",
+		MaxAttempts:  1,
+	}
+}
+
+type outputFlow struct {
+	Time    time.Time `json:"time"`
+	Nsg     string    `json:"nsg"`
+	SrcAddr string    `json:"src_addr"`
+	DstPort int       `json:"dst_port"`
+}
+
+func readOutput(t *testing.T, path string) []outputFlow {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var flows []outputFlow
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var fl outputFlow
+		if err := json.Unmarshal(scanner.Bytes(), &fl); err != nil {
+			t.Fatalf("failed to parse output line %q: %v", scanner.Text(), err)
+		}
+		flows = append(flows, fl)
+	}
+
+	return flows
+}
+
+func TestSearchCmdIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	start := time.Date(2022, 8, 9, 10, 0, 0, 0, time.UTC)
+
+	// two hours of logs, each with a record every 20 minutes
+	for h := 0; h < 2; h++ {
+		hour := start.Add(time.Duration(h) * time.Hour)
+		var records [][]byte
+
+		for m := 0; m < 60; m += 20 {
+			rt := hour.Add(time.Duration(m) * time.Minute)
+			records = append(records, nsgpeektest.FlowLogRecord(testNsgId, rt, testMac, "UserRule_ssh",
+				nsgpeektest.FlowTuple(rt.Add(-time.Minute), "10.0.0.4", "10.0.0.5", 50000+m, 22)))
+		}
+
+		server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(testNsgId, hour, testMac), nsgpeektest.FlowLogBlocks(records...)...)
+	}
+
+	// logs for another nsg in the same account are ignored
+	otherNsg := testNsgIdFor("nsg-other")
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(otherNsg, start, testMac), nsgpeektest.FlowLogBlocks(
+		nsgpeektest.FlowLogRecord(otherNsg, start, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(start, "10.9.9.9", "10.0.0.5", 50000, 22)),
+	)...)
+
+	t.Run("WritesFlowsInTimeRangeInOrder", func(t *testing.T) {
+		cmd := SearchCmd{
+			commonArgs:  testArgs(t),
+			Start:       start,
+			End:         start.Add(time.Hour + time.Minute*30),
+			Parallelism: 2,
+			BlobTimeout: time.Second * 10,
+		}
+
+		if err := cmd.Run(&cliContext{ctx: context.Background()}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		flows := readOutput(t, cmd.File)
+
+		// the records at 10:00, 10:20, 10:40, 11:00 and 11:20 hold flows starting a minute earlier, the
+		// first of which is before the start of the search
+		if len(flows) != 4 {
+			t.Fatalf("unexpected number of flows. want: 4, got: %v (%+v)", len(flows), flows)
+		}
+
+		for i, fl := range flows {
+			if fl.SrcAddr != "10.0.0.4" {
+				t.Errorf("flow from another nsg returned: %+v", fl)
+			}
+			if i > 0 && fl.Time.Before(flows[i-1].Time) {
+				t.Errorf("flows written out of order: %v before %v", flows[i-1].Time, fl.Time)
+			}
+		}
+	})
+}
+
+func TestOutputNotCreatedWhenFindingBlobsFails(t *testing.T) {
+	getLogBlobFinders = func(ctx context.Context, args commonArgs) ([]*logblobfinder.Finder, error) {
+		return nil, errors.New("nsg not found")
+	}
+	t.Cleanup(func() { getLogBlobFinders = newLogBlobFinders })
+
+	existing := func(t *testing.T) commonArgs {
+		args := testArgs(t)
+		args.Overwrite = true
+		if err := os.WriteFile(args.File, []byte("{}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return args
+	}
+
+	cmds := []struct {
+		name string
+		run  func(args commonArgs) error
+	}{
+		{"Search", func(args commonArgs) error {
+			cmd := SearchCmd{commonArgs: args, Start: time.Now().Add(-time.Hour), End: time.Now(), Parallelism: 1, BlobTimeout: time.Second}
+			return cmd.Run(&cliContext{ctx: context.Background()})
+		}},
+		{"Stream", func(args commonArgs) error {
+			cmd := StreamCmd{commonArgs: args}
+			return cmd.Run(&cliContext{ctx: context.Background()})
+		}},
+	}
+
+	for _, c := range cmds {
+		t.Run(c.name+"DoesntCreateFile", func(t *testing.T) {
+			args := testArgs(t)
+			if err := c.run(args); err == nil {
+				t.Fatal("expected an error")
+			}
+			if _, err := os.Stat(args.File); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("expected no output file, got %v", err)
+			}
+		})
+
+		t.Run(c.name+"DoesntTruncateFile", func(t *testing.T) {
+			args := existing(t)
+			if err := c.run(args); err == nil {
+				t.Fatal("expected an error")
+			}
+			if b, err := os.ReadFile(args.File); err != nil || string(b) != "{}\n" {
+				t.Errorf("expected the output file to be left alone, got %q (%v)", b, err)
+			}
+		})
+	}
+}
+
+func TestStreamCmdIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	now := time.Now().UTC()
+	blobPath := nsgpeektest.FlowLogBlobPath(testNsgId, now, testMac)
+	record := func(srcAddr string) []byte {
+		return nsgpeektest.FlowLogRecord(testNsgId, now, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(now, srcAddr, "10.0.0.5", 50000, 22))
+	}
+
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, blobPath, nsgpeektest.FlowLogBlocks(record("10.0.0.1"))...)
+
+	t.Run("WritesBlocksAppendedAfterStarting", func(t *testing.T) {
+		cmd := StreamCmd{commonArgs: testArgs(t)}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		// wait for the stream to note the blocks already in the blob before adding to it
+		waitFor(t, func() bool { return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, blobPath) > 0 })
+
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, blobPath, nsgpeektest.AppendedRecordBlock(record("10.0.0.2")))
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 0 })
+
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, blobPath, nsgpeektest.AppendedRecordBlock(record("10.0.0.3")))
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 1 })
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		flows := readOutput(t, cmd.File)
+		if len(flows) != 2 || flows[0].SrcAddr != "10.0.0.2" || flows[1].SrcAddr != "10.0.0.3" {
+			t.Errorf("expected only the appended flows, got %+v", flows)
+		}
+	})
+
+	t.Run("SortsFlowsWithinSortWindow", func(t *testing.T) {
+		args := testArgs(t)
+		args.SortWindow = time.Minute
+		cmd := StreamCmd{commonArgs: args}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		started := server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, blobPath)
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		// each block is appended once the stream has read the last, so that they arrive in separate batches
+		appendAndWait := func(srcAddr string, at time.Time) {
+			n := server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, blobPath)
+			server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, blobPath, nsgpeektest.AppendedRecordBlock(
+				nsgpeektest.FlowLogRecord(testNsgId, now, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(at, srcAddr, "10.0.0.5", 50000, 22)),
+			))
+			waitFor(t, func() bool {
+				return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, blobPath) > n+1
+			})
+		}
+
+		waitFor(t, func() bool {
+			return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, blobPath) > started
+		})
+
+		appendAndWait("10.0.0.5", now)
+		appendAndWait("10.0.0.4", now.Add(-time.Second*30))
+		appendAndWait("10.0.0.6", now.Add(time.Minute*2))
+
+		// the third flow moves the first two out of the window, the third is held until the stream stops
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 1 })
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, fl := range readOutput(t, cmd.File) {
+			got = append(got, fl.SrcAddr)
+		}
+		if want := []string{"10.0.0.4", "10.0.0.5", "10.0.0.6"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected flows in time order. want: %v, got: %v", want, got)
+		}
+	})
+
+	t.Run("RejectsSortWindowWithCheckpoint", func(t *testing.T) {
+		args := testArgs(t)
+		args.SortWindow = time.Minute
+		cmd := StreamCmd{commonArgs: args, Checkpoint: filepath.Join(t.TempDir(), "checkpoint.json")}
+
+		if err := cmd.Run(&cliContext{ctx: context.Background()}); err == nil || !strings.Contains(err.Error(), "sort window") {
+			t.Errorf("expected a sort window error, got %v", err)
+		}
+	})
+}
+
+func TestStreamCmdMultipleNicsIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	now := time.Now().UTC()
+	macs := []string{"000D3AD488D1", "000D3AD488D2"}
+	record := func(mac string, srcAddr string) []byte {
+		return nsgpeektest.FlowLogRecord(testNsgId, now, mac, "UserRule_ssh", nsgpeektest.FlowTuple(now, srcAddr, "10.0.0.5", 50000, 22))
+	}
+
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(testNsgId, now, macs[0]), nsgpeektest.FlowLogBlocks(record(macs[0], "10.0.0.1"))...)
+
+	t.Run("WritesBlocksFromEachNic", func(t *testing.T) {
+		cmd := StreamCmd{commonArgs: testArgs(t)}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		firstPath := nsgpeektest.FlowLogBlobPath(testNsgId, now, macs[0])
+		waitFor(t, func() bool { return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, firstPath) > 0 })
+
+		// a second nic starts logging after the stream has started
+		secondPath := nsgpeektest.FlowLogBlobPath(testNsgId, now, macs[1])
+		server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, secondPath, nsgpeektest.FlowLogBlocks(record(macs[1], "10.0.1.1"))...)
+		waitFor(t, func() bool {
+			return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, secondPath) > 0
+		})
+
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, firstPath, nsgpeektest.AppendedRecordBlock(record(macs[0], "10.0.0.2")))
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, secondPath, nsgpeektest.AppendedRecordBlock(record(macs[1], "10.0.1.2")))
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 2 })
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, fl := range readOutput(t, cmd.File) {
+			got = append(got, fl.SrcAddr)
+		}
+		sort.Strings(got)
+
+		// the second nic's blob was written after the stream started so all of it is new
+		if want := []string{"10.0.0.2", "10.0.1.1", "10.0.1.2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the new flows from each nic. want: %v, got: %v", want, got)
+		}
+	})
+}
+
+func TestStreamCmdRolloverIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	now := time.Now().UTC()
+	lastHour := now.Add(-time.Hour)
+	record := func(at time.Time, srcAddr string) []byte {
+		return nsgpeektest.FlowLogRecord(testNsgId, at, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(at, srcAddr, "10.0.0.5", 50000, 22))
+	}
+
+	oldPath := nsgpeektest.FlowLogBlobPath(testNsgId, lastHour, testMac)
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, oldPath, nsgpeektest.FlowLogBlocks(record(lastHour, "10.0.0.1"))...)
+
+	t.Run("DrainsPreviousBlobAndReadsNewBlobFromStart", func(t *testing.T) {
+		cmd := StreamCmd{commonArgs: testArgs(t)}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		waitFor(t, func() bool { return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, oldPath) > 0 })
+
+		// the next hour's blob is created with flows already in it, and the last flows of the previous hour
+		// are committed after the stream has moved on to it
+		server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(testNsgId, now, testMac), nsgpeektest.FlowLogBlocks(record(now, "10.0.0.3"))...)
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 0 })
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, oldPath, nsgpeektest.AppendedRecordBlock(record(lastHour, "10.0.0.2")))
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 1 })
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, fl := range readOutput(t, cmd.File) {
+			got = append(got, fl.SrcAddr)
+		}
+		sort.Strings(got)
+
+		if want := []string{"10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the flows either side of the rollover. want: %v, got: %v", want, got)
+		}
+	})
+}
+
+func TestStreamCmdMultipleNsgsIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	now := time.Now().UTC()
+	nsgIds := []string{testNsgIdFor("nsg-hub"), testNsgIdFor("nsg-spoke")}
+	record := func(nsgId string, srcAddr string) []byte {
+		return nsgpeektest.FlowLogRecord(nsgId, now, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(now, srcAddr, "10.0.0.5", 50000, 22))
+	}
+
+	for _, id := range nsgIds {
+		server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(id, now, testMac), nsgpeektest.FlowLogBlocks(record(id, "10.0.0.1"))...)
+	}
+
+	t.Run("WritesFlowsFromEachNsg", func(t *testing.T) {
+		args := testArgs(t)
+		args.NsgName = []string{"nsg-hub", "nsg-spoke"}
+		cmd := StreamCmd{commonArgs: args}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		for _, id := range nsgIds {
+			blobPath := nsgpeektest.FlowLogBlobPath(id, now, testMac)
+			waitFor(t, func() bool { return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, blobPath) > 0 })
+		}
+
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(nsgIds[0], now, testMac), nsgpeektest.AppendedRecordBlock(record(nsgIds[0], "10.0.0.2")))
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(nsgIds[1], now, testMac), nsgpeektest.AppendedRecordBlock(record(nsgIds[1], "10.0.0.3")))
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 1 })
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got := make(map[string]string)
+		for _, fl := range readOutput(t, cmd.File) {
+			got[fl.Nsg] = fl.SrcAddr
+		}
+
+		if want := map[string]string{"NSG-HUB": "10.0.0.2", "NSG-SPOKE": "10.0.0.3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected flows by nsg. want: %v, got: %v", want, got)
+		}
+	})
+}
+
+func TestStreamCmdCheckpointIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	now := time.Now().UTC()
+	lastHour := now.Add(-time.Hour)
+	lastHourPath := nsgpeektest.FlowLogBlobPath(testNsgId, lastHour, testMac)
+	currentPath := nsgpeektest.FlowLogBlobPath(testNsgId, now, testMac)
+	record := func(t time.Time, srcAddr string) []byte {
+		return nsgpeektest.FlowLogRecord(testNsgId, t, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(t, srcAddr, "10.0.0.5", 50000, 22))
+	}
+
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, lastHourPath, nsgpeektest.FlowLogBlocks(record(lastHour, "10.0.0.1"))...)
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	run := func(t *testing.T, cmd *StreamCmd, whileRunning func()) []outputFlow {
+		ctx, cancel := context.WithCancel(context.Background())
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		whileRunning()
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return readOutput(t, cmd.File)
+	}
+
+	t.Run("ResumesAcrossHourRollover", func(t *testing.T) {
+		cmd := &StreamCmd{commonArgs: testArgs(t), Checkpoint: checkpointPath}
+
+		flows := run(t, cmd, func() {
+			waitFor(t, func() bool {
+				return server.BlockListRequests(testAccount, nsgpeektest.FlowLogContainer, lastHourPath) > 0
+			})
+			server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, lastHourPath, nsgpeektest.AppendedRecordBlock(record(lastHour, "10.0.0.2")))
+			waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 0 })
+
+			// wait for the position after the appended block to be saved
+			skipped := nsgpeektest.FlowLogBlocks(record(lastHour, "10.0.0.1"))
+			waitFor(t, func() bool { return checkpointOffset(t, checkpointPath) > int64(len(skipped[0])+len(skipped[1])) })
+		})
+
+		if len(flows) != 1 || flows[0].SrcAddr != "10.0.0.2" {
+			t.Fatalf("expected only the appended flow before restarting, got %+v", flows)
+		}
+
+		// while stopped the last hour's blob is finished and the next hour's is started
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, lastHourPath, nsgpeektest.AppendedRecordBlock(record(lastHour, "10.0.0.3")))
+		server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, currentPath, nsgpeektest.FlowLogBlocks(record(now, "10.0.0.4"))...)
+
+		cmd = &StreamCmd{commonArgs: testArgs(t), Checkpoint: checkpointPath}
+
+		flows = run(t, cmd, func() {
+			waitFor(t, func() bool { _, err := os.Stat(cmd.File); return err == nil })
+			waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 1 })
+			server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, currentPath, nsgpeektest.AppendedRecordBlock(record(now, "10.0.0.5")))
+			waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 2 })
+		})
+
+		var got []string
+		for _, fl := range flows {
+			got = append(got, fl.SrcAddr)
+		}
+		sort.Strings(got)
+
+		if want := []string{"10.0.0.3", "10.0.0.4", "10.0.0.5"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the flows written while stopped and after restarting. want: %v, got: %v", want, got)
+		}
+	})
+}
+
+func TestStreamCmdCheckpointPerSourceIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	now := time.Now().UTC()
+	hub, spoke := testNsgIdFor("nsg-hub"), testNsgIdFor("nsg-spoke")
+	record := func(nsgId string, at time.Time, srcAddr string) []byte {
+		return nsgpeektest.FlowLogRecord(nsgId, at, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(at, srcAddr, "10.0.0.5", 50000, 22))
+	}
+	put := func(nsgId string, at time.Time, srcAddr string) (string, int64) {
+		path := nsgpeektest.FlowLogBlobPath(nsgId, at, testMac)
+		blocks := nsgpeektest.FlowLogBlocks(record(nsgId, at, srcAddr))
+		server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, path, blocks...)
+		return path, int64(len(blocks[0]) + len(blocks[1]))
+	}
+
+	// the hub was checkpointed two hours ago and the spoke an hour ago, after it had finished the blob
+	// from two hours ago
+	hubPath, hubOffset := put(hub, now.Add(-2*time.Hour), "10.0.1.1")
+	put(spoke, now.Add(-2*time.Hour), "10.0.2.1")
+	spokePath, spokeOffset := put(spoke, now.Add(-time.Hour), "10.0.2.2")
+
+	args := testArgs(t)
+	args.NsgName = []string{"nsg-hub", "nsg-spoke"}
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	cp, err := checkpoint.Load(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, offset := range map[string]int64{hubPath: hubOffset, spokePath: spokeOffset} {
+		if err := cp.Set(checkpoint.Key(testBlobUrl(t, args, path)), path, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stalePath := nsgpeektest.FlowLogBlobPath(testNsgIdFor("nsg-other"), now.Add(-5*time.Hour), testMac)
+	if err := cp.Set("https://stale.blob.core.windows.net/"+stalePath, stalePath, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// written while the stream was stopped
+	server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, hubPath, nsgpeektest.AppendedRecordBlock(record(hub, now.Add(-2*time.Hour), "10.0.1.2")))
+	put(hub, now, "10.0.1.3")
+	server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, spokePath, nsgpeektest.AppendedRecordBlock(record(spoke, now.Add(-time.Hour), "10.0.2.3")))
+
+	t.Run("CatchesUpEachSourceFromItsOwnCheckpoint", func(t *testing.T) {
+		cmd := StreamCmd{commonArgs: args, Checkpoint: checkpointPath}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		waitFor(t, func() bool { _, err := os.Stat(cmd.File); return err == nil })
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 2 })
+		// give anything read twice time to turn up
+		time.Sleep(time.Millisecond * 500)
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, fl := range readOutput(t, cmd.File) {
+			got = append(got, fl.SrcAddr)
+		}
+		sort.Strings(got)
+
+		if want := []string{"10.0.1.2", "10.0.1.3", "10.0.2.3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected each flow written while stopped once. want: %v, got: %v", want, got)
+		}
+
+		saved, err := checkpoint.Load(checkpointPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := saved.Offset("https://stale.blob.core.windows.net/" + stalePath); ok {
+			t.Error("checkpoint entry for an nsg that isn't being streamed wasn't dropped")
+		}
+	})
+}
+
+// testBlobUrl returns the URL the stream reads the blob at path from.
+func testBlobUrl(t *testing.T, args commonArgs, path string) string {
+	finders, err := getLogBlobFinders(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range finders {
+		blobs, err := f.FindSpecific(time.Time{}, time.Now().UTC().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range blobs {
+			if b.Path == path {
+				return b.URL()
+			}
+		}
+	}
+
+	t.Fatalf("blob %v not found", path)
+	return ""
+}
+
+func TestStreamCmdSinceIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	now := time.Now().UTC()
+	record := func(recordTime time.Time, tupleTime time.Time, srcAddr string) []byte {
+		return nsgpeektest.FlowLogRecord(testNsgId, recordTime, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(tupleTime, srcAddr, "10.0.0.5", 50000, 22))
+	}
+
+	// the replay reads whole hourly blobs but only writes the flows from after it starts
+	threeHoursAgo, twoHoursAgo, lastHour := now.Add(-time.Hour*3), now.Add(-time.Hour*2), now.Add(-time.Hour)
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(testNsgId, threeHoursAgo, testMac), nsgpeektest.FlowLogBlocks(
+		record(threeHoursAgo, threeHoursAgo, "10.0.0.1"),
+	)...)
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(testNsgId, twoHoursAgo, testMac), nsgpeektest.FlowLogBlocks(
+		record(twoHoursAgo, now.Add(-time.Minute*140), "10.0.0.2"),
+		record(twoHoursAgo, now.Add(-time.Minute*130), "10.0.0.3"),
+	)...)
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, nsgpeektest.FlowLogBlobPath(testNsgId, lastHour, testMac), nsgpeektest.FlowLogBlocks(
+		record(lastHour, now.Add(-time.Minute*80), "10.0.0.4"),
+		record(lastHour, now.Add(-time.Minute*70), "10.0.0.5"),
+	)...)
+
+	currentPath := nsgpeektest.FlowLogBlobPath(testNsgId, now, testMac)
+	server.PutBlob(testAccount, nsgpeektest.FlowLogContainer, currentPath, nsgpeektest.FlowLogBlocks(record(now, now.Add(-time.Minute), "10.0.0.6"))...)
+
+	t.Run("ReplaysRecentFlowsInOrderThenFollowsNewOnes", func(t *testing.T) {
+		cmd := StreamCmd{commonArgs: testArgs(t), Since: time.Minute * 150}
+		ctx, cancel := context.WithCancel(context.Background())
+
+		errCh := make(chan error)
+		go func() { errCh <- cmd.Run(&cliContext{ctx: ctx}) }()
+
+		waitFor(t, func() bool { _, err := os.Stat(cmd.File); return err == nil })
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 4 })
+
+		server.AppendBlocks(testAccount, nsgpeektest.FlowLogContainer, currentPath, nsgpeektest.AppendedRecordBlock(record(now, now, "10.0.0.7")))
+		waitFor(t, func() bool { return len(readOutput(t, cmd.File)) > 5 })
+
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, fl := range readOutput(t, cmd.File) {
+			got = append(got, fl.SrcAddr)
+		}
+
+		// the earlier hours are written oldest first before the current hour's flows
+		if want := []string{"10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected the recent flows once each in order then the new flow. want: %v, got: %v", want, got)
+		}
+	})
+}
+
+// checkpointOffset returns the offset saved for the only blob in a checkpoint file, or -1 if there isn't one.
+func checkpointOffset(t *testing.T, path string) int64 {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1
+	}
+
+	var cp struct {
+		Blobs map[string]struct{ Offset int64 }
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		t.Fatalf("invalid checkpoint: %v", err)
+	}
+
+	for _, b := range cp.Blobs {
+		return b.Offset
+	}
+	return -1
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 10)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestGenerateCmdIntegration(t *testing.T) {
+	server := nsgpeektest.NewBlobServer()
+	defer server.Close()
+	useFakeStorage(t, server)
+
+	start := time.Date(2022, 8, 9, 10, 30, 0, 0, time.UTC)
+
+	t.Run("WritesLogsThatCanBeSearched", func(t *testing.T) {
+		gen := GenerateCmd{
+			NsgId:        testNsgId,
+			Start:        start,
+			End:          start.Add(time.Hour),
+			ContainerUrl: server.ContainerSasUrl(testAccount, nsgpeektest.FlowLogContainer),
+			Version:      2,
+			Nics:         1,
+			Rate:         2,
+			Rules:        []string{"UserRule_ssh"},
+			DenyRule:     "DefaultRule_DenyAllInBound",
+			SrcPool:      []string{"10.0.0.0/24"},
+			DstPool:      []string{"10.1.0.0/24"},
+			DstPorts:     []int{22},
+			Seed:         1,
+		}
+
+		if err := gen.Run(&cliContext{ctx: context.Background()}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if names := server.BlobNames(testAccount, nsgpeektest.FlowLogContainer); len(names) != 2 {
+			t.Fatalf("expected a blob for each hour, got %v", names)
+		}
+
+		search := SearchCmd{
+			commonArgs:  testArgs(t),
+			Start:       start,
+			End:         start.Add(time.Hour),
+			Parallelism: 2,
+			BlobTimeout: time.Second * 10,
+		}
+
+		if err := search.Run(&cliContext{ctx: context.Background()}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if flows := readOutput(t, search.File); len(flows) != 120 {
+			t.Errorf("unexpected number of flows. want: 120, got: %v", len(flows))
+		}
+	})
+
+	t.Run("RejectsStartNotBeforeEnd", func(t *testing.T) {
+		for _, end := range []time.Time{start, start.Add(-time.Hour)} {
+			gen := GenerateCmd{
+				NsgId:  testNsgId,
+				Start:  start,
+				End:    end,
+				OutDir: t.TempDir(),
+				Nics:   1,
+			}
+
+			err := gen.Run(&cliContext{ctx: context.Background()})
+			if err == nil || !strings.Contains(err.Error(), "start must be before end") {
+				t.Errorf("expected an error for end %v, got %v", end, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/tmeadon/nsgpeek/pkg/azure"
+)
+
+// searchProgress reports how many blobs and bytes a search has downloaded, with an estimate of the
+// time remaining, on a spinner written to stderr.
+type searchProgress struct {
+	mu         sync.Mutex
+	spin       *spinner.Spinner
+	started    time.Time
+	totalBlobs int
+	doneBlobs  int
+	failed     int
+	totalBytes int64
+	readBytes  int64
+}
+
+func newSearchProgress(blobs []azure.Blob) *searchProgress {
+	p := &searchProgress{
+		spin:       spinner.New(spinner.CharSets[43], 100*time.Millisecond, spinner.WithWriter(os.Stderr)),
+		started:    time.Now(),
+		totalBlobs: len(blobs),
+	}
+
+	for _, b := range blobs {
+		p.totalBytes += b.Size
+	}
+
+	p.update()
+	return p
+}
+
+func (p *searchProgress) start() {
+	p.spin.Start()
+}
+
+func (p *searchProgress) stop() {
+	p.spin.Stop()
+}
+
+func (p *searchProgress) addBytes(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.readBytes += int64(n)
+	p.update()
+}
+
+func (p *searchProgress) blobDone() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doneBlobs++
+	p.update()
+}
+
+// blobFailed counts a blob that couldn't be read as done so the estimate isn't thrown off.
+func (p *searchProgress) blobFailed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doneBlobs++
+	p.failed++
+	p.update()
+}
+
+func (p *searchProgress) update() {
+	suffix := fmt.Sprintf("  read %v/%v blobs, %v", p.doneBlobs, p.totalBlobs, formatBytes(p.readBytes))
+	if p.failed > 0 {
+		suffix = fmt.Sprintf("%v (%v failed)", suffix, p.failed)
+	}
+	if p.totalBytes > 0 {
+		suffix += fmt.Sprintf(" of %v", formatBytes(p.totalBytes))
+	}
+	if eta, ok := p.eta(); ok {
+		suffix += fmt.Sprintf(", about %v remaining", eta)
+	}
+
+	p.spin.Lock()
+	p.spin.Suffix = suffix
+	p.spin.Unlock()
+}
+
+// eta estimates the time remaining from the rate bytes have been read so far, falling back to the rate
+// blobs have been completed when blob sizes aren't known.
+func (p *searchProgress) eta() (time.Duration, bool) {
+	elapsed := time.Since(p.started)
+	var remaining float64
+
+	switch {
+	case p.totalBytes > 0 && p.readBytes > 0:
+		remaining = float64(p.totalBytes-p.readBytes) / float64(p.readBytes)
+	case p.doneBlobs > 0:
+		remaining = float64(p.totalBlobs-p.doneBlobs) / float64(p.doneBlobs)
+	default:
+		return 0, false
+	}
+
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return (time.Duration(float64(elapsed) * remaining)).Round(time.Second), true
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%v B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tmeadon/nsgpeek/pkg/azure"
+)
+
+// isInteractive reports whether stdin and stderr are both terminals so the user can answer a prompt.
+func isInteractive() bool {
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		info, err := f.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// promptForNsg asks the user to choose between NSGs with the same name and returns the chosen resource ID.
+func promptForNsg(e *azure.AmbiguousNsgError) (string, error) {
+	fmt.Fprintf(os.Stderr, "found %v nsgs named '%v':\n", len(e.Candidates), e.Name)
+	for i, c := range e.Candidates {
+		fmt.Fprintf(os.Stderr, "  %v) %v\n", i+1, c)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	for {
+		fmt.Fprintf(os.Stderr, "choose an nsg [1-%v]: ", len(e.Candidates))
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read nsg choice: %w", err)
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(line))
+		if err == nil && n >= 1 && n <= len(e.Candidates) {
+			return e.Candidates[n-1], nil
+		}
+	}
+}
@@ -2,91 +2,402 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/tmeadon/nsgpeek/pkg/azure"
 	"github.com/tmeadon/nsgpeek/pkg/blobreader"
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+	"github.com/tmeadon/nsgpeek/pkg/flowmerge"
 	"github.com/tmeadon/nsgpeek/pkg/flowwriter"
+	"github.com/tmeadon/nsgpeek/pkg/localstorage"
 	"github.com/tmeadon/nsgpeek/pkg/logblobfinder"
 )
 
 type SearchCmd struct {
 	commonArgs
-	Start time.Time `required:"" help:"Start time (UTC) for the log search in format '2006-01-02 15:04:05'" format:"2006-01-02 15:04:05"`
-	End   time.Time `required:"" help:"End time (UTC) for the log search in format '2006-01-02 15:04:05'"  format:"2006-01-02 15:04:05"`
+	Start       time.Time     `required:"" help:"Start time (UTC) for the log search in format '2006-01-02 15:04:05'" format:"2006-01-02 15:04:05"`
+	End         time.Time     `required:"" help:"End time (UTC) for the log search in format '2006-01-02 15:04:05'"  format:"2006-01-02 15:04:05"`
+	Parallelism int           `default:"8" help:"(Optional) Maximum number of blobs to download at once"`
+	BlobTimeout time.Duration `default:"1m" help:"(Optional) Give up on a blob if no data is received from it for this long"`
+	FromDir     string        `xor:"source" type:"existingdir" help:"(Optional) Read flow logs downloaded to this directory instead of from Azure"`
+	FromArchive string        `xor:"source" type:"existingfile" help:"(Optional) Read flow logs from this zip, tar or tar.gz export instead of from Azure"`
+}
+
+// defaultSearchSortWindow is used when no sort window is given so that merged search results are written
+// as they arrive while still correcting the small amount of disorder between blocks in a blob.
+const defaultSearchSortWindow = time.Minute
+
+// searchReadAhead is how many blobs each worker may read ahead of the merge.  Once that many blobs are
+// waiting for the merge to start on them no more are handed out, so a slow blob can't leave the rest of a
+// wide search decoded in memory.
+const searchReadAhead = 2
+
+// blobStartLeeway allows for tuples near the start of an hour being written to the blob for that hour
+// despite being stamped slightly earlier.
+const blobStartLeeway = time.Minute * 2
+
+// findSpecific finds the blobs covering start to end in every finder's storage account.  Accounts that
+// don't have any logs for the nsg yet are skipped as long as one of them does.
+func findSpecific(finders []*logblobfinder.Finder, start time.Time, end time.Time) ([]azure.Blob, error) {
+	var blobs []azure.Blob
+	found := false
+
+	for _, f := range finders {
+		b, err := f.FindSpecific(start, end)
+		if errors.Is(err, logblobfinder.ErrBlobPrefixNotFound) {
+			log.Printf("no logs found for nsg %v in flow log %v", f.Nsg(), f.FlowLog.Name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		found = true
+		blobs = append(blobs, b...)
+	}
+
+	if !found {
+		return nil, logblobfinder.ErrBlobPrefixNotFound
+	}
+
+	return blobs, nil
+}
+
+// logBlobFinders returns a finder for each NSG in the local copy of the flow logs given by --from-dir or
+// --from-archive, or finders for the NSGs' flow logs in Azure otherwise.  The returned function releases
+// the local copy.
+func (s *SearchCmd) logBlobFinders(ctx context.Context) ([]*logblobfinder.Finder, func() error, error) {
+	var getter *localstorage.BlobGetter
+
+	if s.FromDir != "" || s.FromArchive != "" {
+		for _, name := range s.NsgName {
+			if azure.IsNsgPattern(name) {
+				return nil, nil, fmt.Errorf("nsg name globs can't be used with --from-dir or --from-archive, got '%v'", name)
+			}
+		}
+	}
+
+	switch {
+	case s.FromDir != "":
+		getter = localstorage.NewDirBlobGetter(s.FromDir)
+	case s.FromArchive != "":
+		var err error
+		if getter, err = localstorage.NewArchiveBlobGetter(s.FromArchive); err != nil {
+			return nil, nil, err
+		}
+	default:
+		finders, err := getLogBlobFinders(ctx, s.commonArgs)
+		return finders, func() error { return nil }, err
+	}
+
+	finders := make([]*logblobfinder.Finder, 0, len(s.NsgName))
+	for _, name := range s.NsgName {
+		finders = append(finders, logblobfinder.NewLocalLogBlobFinder(getter, name))
+	}
+
+	return finders, getter.Close, nil
+}
+
+// searchJob is a blob to be read by a search worker along with the merge input its tuples are sent to.
+type searchJob struct {
+	blob     azure.Blob
+	start    time.Time
+	tuplesCh chan<- []flowlog.FlowTuple
 }
 
 func (s *SearchCmd) Run(ctx *cliContext) error {
-	finder, err := logblobfinder.NewLogBlobFinder(subs, s.NsgName, context.Background(), cred)
+	if s.Parallelism < 1 {
+		return fmt.Errorf("parallelism must be at least 1, got %v", s.Parallelism)
+	}
+
+	if s.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, got %v", s.MaxAttempts)
+	}
+
+	if s.BlobTimeout <= 0 {
+		return fmt.Errorf("blob timeout must be greater than zero, got %v", s.BlobTimeout)
+	}
+
+	if s.SortWindow == 0 {
+		s.SortWindow = defaultSearchSortWindow
+	}
+
+	finders, closeSource, err := s.logBlobFinders(ctx.ctx)
 	if err != nil {
 		return err
 	}
+	defer closeSource()
 
-	blobs, err := finder.FindSpecific(s.Start, s.End)
+	blobs, err := findSpecific(finders, s.Start, s.End)
 	if err != nil {
 		return err
 	}
 
-	dataCh := make(chan [][]byte)
-	errCh := make(chan error)
-	waitCh := make(chan bool)
-	go readBlobs(blobs, dataCh, errCh, waitCh)
+	jobs := make([]searchJob, 0, len(blobs))
+
+	for _, b := range blobs {
+		start, err := logblobfinder.BlobStartTime(b.Path)
+		if err != nil {
+			return err
+		}
+
+		jobs = append(jobs, searchJob{blob: b, start: start.Add(-blobStartLeeway)})
+	}
+
+	// jobs are handed out in time order so that the blob the merge is waiting on is never stuck
+	// behind later blobs waiting for a worker
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].start.Before(jobs[j].start) })
+
+	inputs := make([]flowmerge.Input, 0, len(jobs))
+	for i := range jobs {
+		tuplesCh := make(chan []flowlog.FlowTuple)
+		jobs[i].tuplesCh = tuplesCh
+		inputs = append(inputs, flowmerge.Input{Start: jobs[i].start, Tuples: tuplesCh})
+	}
 
-	writers, err := initWriterGroup(s.commonArgs)
+	// the writers are created once the blobs are found so that an output file isn't created or
+	// truncated by a search that never starts
+	writers, err := initWriterGroup(s.commonArgs, flowwriter.NewTimeFilter(s.Start, s.End))
 	if err != nil {
 		return err
 	}
 
-	writers.AddFilter(flowwriter.NewTimeFilter(s.Start, s.End))
+	progress := newSearchProgress(blobs)
+	progress.start()
+	defer progress.stop()
+
+	// the workers, the dispatcher and the merge are stopped whenever the search returns
+	workCtx, cancel := context.WithCancel(ctx.ctx)
+	defer cancel()
+
+	jobCh := make(chan searchJob)
+	errCh := make(chan error)
+	failures := &blobFailures{}
+	opts := searchWorkerOptions{
+		parseErrorPolicy: parseErrorPolicies[s.OnParseError],
+		retry:            s.retryPolicy(),
+		timeout:          s.BlobTimeout,
+		progress:         progress,
+		window:           make(chan struct{}, s.Parallelism*searchReadAhead),
+	}
+
+	for i := 0; i < s.Parallelism; i++ {
+		go searchWorker(workCtx, jobCh, opts, failures, errCh)
+	}
+
+	go dispatchSearchJobs(workCtx, jobs, jobCh, opts.window)
+
+	mergedCh := make(chan []flowlog.FlowTuple)
+	go flowmerge.Merge(workCtx, inputs, mergedCh)
 
-read:
 	for {
 		select {
-		case data := <-dataCh:
-			for _, d := range data {
-				writers.WriteFlowBlock(d)
+		case tuples, ok := <-mergedCh:
+			progress.stop()
+
+			if !ok {
+				if err := writers.Close(); err != nil {
+					return err
+				}
+				return failures.report(len(blobs))
+			}
+
+			if err := writers.WriteFlowTuples(tuples); err != nil {
+				writers.Close()
+				return err
 			}
+
+			progress.start()
 		case err := <-errCh:
-			writers.Flush()
+			progress.stop()
+			writers.Close()
 			return fmt.Errorf("error: %w", err)
-		case <-waitCh:
-			writers.Flush()
-			break read
+		case <-ctx.ctx.Done():
+			progress.stop()
+			if err := writers.Close(); err != nil {
+				return err
+			}
+			return errors.New("search cancelled, results are incomplete")
+		}
+	}
+}
+
+// blobFailure records a blob that couldn't be read after retrying.
+type blobFailure struct {
+	path string
+	err  error
+}
+
+// blobFailures collects the blobs a search couldn't read so that they can be reported at the end.
+type blobFailures struct {
+	mu       sync.Mutex
+	failures []blobFailure
+}
+
+func (f *blobFailures) add(path string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, blobFailure{path, err})
+}
+
+// report prints a summary of the failed blobs and returns an error if there were any.
+func (f *blobFailures) report(total int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.failures) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "\nfailed to read %v of %v blobs, results are incomplete:\n", len(f.failures), total)
+	for _, bf := range f.failures {
+		fmt.Fprintf(os.Stderr, "  %v: %v\n", bf.path, bf.err)
+	}
+
+	return fmt.Errorf("failed to read %v of %v blobs", len(f.failures), total)
+}
+
+// dispatchSearchJobs hands jobs to the workers in the order the merge starts on them, waiting for a place
+// in window before each one.  The merge only ever waits for the earliest blob it hasn't started on, which
+// always has a place, so the window can't hold it up.
+func dispatchSearchJobs(ctx context.Context, jobs []searchJob, jobCh chan<- searchJob, window chan struct{}) {
+	defer close(jobCh)
+
+	for _, j := range jobs {
+		select {
+		case window <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case jobCh <- j:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// searchWorker reads each blob it is given into memory and hands its tuples off to the merge, so the
+// worker can move on to the next blob without waiting for the merge to need them.  Blobs that can't be
+// read are recorded in failures and contribute no tuples.
+func searchWorker(ctx context.Context, jobCh <-chan searchJob, opts searchWorkerOptions, failures *blobFailures, errCh chan error) {
+	for j := range jobCh {
+		j.blob.Retry = opts.retry
+
+		blocks, err := readBlob(ctx, j.blob, opts.timeout, opts.progress)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			failures.add(j.blob.Path, err)
+			opts.progress.blobFailed()
+			<-opts.window
+			close(j.tuplesCh)
+			continue
+		}
+
+		batches, err := decodeBlocks(blocks, opts.parseErrorPolicy)
+		if err != nil {
+			<-opts.window
+			close(j.tuplesCh)
+
+			select {
+			case errCh <- fmt.Errorf("failed to read blob %v: %w", j.blob.Path, err):
+			case <-ctx.Done():
+			}
+			return
+		}
+
+		opts.progress.blobDone()
+		go sendBatches(ctx, batches, j.tuplesCh, opts.window)
+	}
+}
+
+type searchWorkerOptions struct {
+	parseErrorPolicy flowwriter.ParseErrorPolicy
+	retry            azure.RetryPolicy
+	timeout          time.Duration
+	progress         *searchProgress
+	// window limits the blobs read ahead of the merge, see dispatchSearchJobs
+	window chan struct{}
+}
+
+// sendBatches sends a blob's batches to its merge input, giving up the blob's place in window once the
+// merge has started on them.  It gives up if ctx is cancelled first.
+func sendBatches(ctx context.Context, batches [][]flowlog.FlowTuple, tuplesCh chan<- []flowlog.FlowTuple, window chan struct{}) {
+	for i, b := range batches {
+		select {
+		case tuplesCh <- b:
+		case <-ctx.Done():
+			return
 		}
 
+		if i == 0 {
+			<-window
+		}
 	}
 
-	return nil
+	if len(batches) == 0 {
+		<-window
+	}
+	close(tuplesCh)
 }
 
-func readBlobs(blobs []azure.Blob, dataCh chan [][]byte, errCh chan error, waitCh chan bool) {
-	var wg sync.WaitGroup
+// decodeBlocks decodes each block in a blob, returning the tuples from each block in time order.
+func decodeBlocks(blocks [][]byte, policy flowwriter.ParseErrorPolicy) ([][]flowlog.FlowTuple, error) {
+	batches := make([][]flowlog.FlowTuple, 0, len(blocks))
 
-	for i, b := range blobs {
-		wg.Add(1)
-		go readBlob(i, b, dataCh, errCh, &wg)
+	for _, d := range blocks {
+		tuples, err := flowwriter.DecodeFlowBlock(d, policy)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(tuples, func(i, j int) bool { return tuples[i].Time.Before(tuples[j].Time) })
+		batches = append(batches, tuples)
 	}
 
-	wg.Wait()
-	close(waitCh)
+	return batches, nil
 }
 
-func readBlob(i int, b azure.Blob, dataCh chan [][]byte, errCh chan error, wg *sync.WaitGroup) {
-	defer wg.Done()
+// readBlob reads every block in a blob, failing if no progress is made within timeout.
+func readBlob(ctx context.Context, b azure.Blob, timeout time.Duration, progress *searchProgress) ([][]byte, error) {
+	// stop the reader if this blob is abandoned
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	dataCh := make(chan [][]byte)
 	childErrCh := make(chan error)
 	blobReader := blobreader.NewBlobReader(&b, dataCh, childErrCh)
 
 	doneCh := make(chan bool)
-	go blobReader.Read(doneCh)
-
-	select {
-	case <-doneCh:
-	case err := <-childErrCh:
-		errCh <- fmt.Errorf("failed to read blob %v: %w", b.Path, err)
-	case <-time.After(time.Minute):
-		errCh <- fmt.Errorf("timed out reading blob %v", b.URL())
+	go blobReader.Read(ctx, doneCh)
+
+	var blocks [][]byte
+
+	for {
+		select {
+		case data := <-dataCh:
+			for _, d := range data {
+				progress.addBytes(len(d))
+			}
+			blocks = append(blocks, data...)
+		case <-doneCh:
+			return blocks, nil
+		case err := <-childErrCh:
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(timeout):
+			return nil, fmt.Errorf("timed out after %v waiting for blob", timeout)
+		}
 	}
 }
@@ -0,0 +1,217 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/internal/nsgpeektest"
+	"github.com/tmeadon/nsgpeek/pkg/azure"
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+	"github.com/tmeadon/nsgpeek/pkg/flowmerge"
+	"github.com/tmeadon/nsgpeek/pkg/flowwriter"
+)
+
+// gatedStore is a blob holding a single record that can't be read until gate is closed.
+type gatedStore struct {
+	blocks [][]byte
+	gate   chan struct{}
+	reads  *int32
+}
+
+func (s *gatedStore) GetBlocks(ctx context.Context) ([]azure.BlobBlock, error) {
+	atomic.AddInt32(s.reads, 1)
+
+	select {
+	case <-s.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	blocks := make([]azure.BlobBlock, len(s.blocks))
+	for i, b := range s.blocks {
+		blocks[i] = azure.BlobBlock{Name: fmt.Sprint(i), Size: int64(len(b))}
+	}
+	return blocks, nil
+}
+
+func (s *gatedStore) ReadBlock(ctx context.Context, block *azure.BlobBlock, blockIndex int64) ([]byte, error) {
+	var i int
+	fmt.Sscan(block.Name, &i)
+	return s.blocks[i], nil
+}
+
+func (s *gatedStore) URL() string {
+	return "https://stg.blob.core.windows.net/flows"
+}
+
+// runSearchWorkers starts workers, the dispatcher and the merge on a job for each store, as SearchCmd.Run
+// does, returning the merged tuples and the errors sent by the workers.
+func runSearchWorkers(ctx context.Context, stores []*gatedStore, workers int, window int) (<-chan []flowlog.FlowTuple, <-chan error) {
+	now := time.Now().UTC().Truncate(time.Hour)
+	jobs := make([]searchJob, len(stores))
+	inputs := make([]flowmerge.Input, len(stores))
+
+	for i, store := range stores {
+		at := now.Add(time.Duration(i) * time.Minute)
+		tuplesCh := make(chan []flowlog.FlowTuple)
+		jobs[i] = searchJob{blob: azure.Blob{Store: store, Path: fmt.Sprint(i)}, start: at, tuplesCh: tuplesCh}
+		inputs[i] = flowmerge.Input{Start: at, Tuples: tuplesCh}
+	}
+
+	jobCh := make(chan searchJob)
+	errCh := make(chan error)
+	opts := searchWorkerOptions{
+		parseErrorPolicy: flowwriter.FailOnParseError,
+		retry:            azure.RetryPolicy{MaxAttempts: 1},
+		timeout:          time.Minute,
+		progress:         newSearchProgress(nil),
+		window:           make(chan struct{}, window),
+	}
+
+	for i := 0; i < workers; i++ {
+		go searchWorker(ctx, jobCh, opts, &blobFailures{}, errCh)
+	}
+	go dispatchSearchJobs(ctx, jobs, jobCh, opts.window)
+
+	mergedCh := make(chan []flowlog.FlowTuple)
+	go flowmerge.Merge(ctx, inputs, mergedCh)
+
+	return mergedCh, errCh
+}
+
+// testStores returns n stores that each hold a record a minute after the previous store's, all sharing
+// reads and gated by open.
+func testStores(n int, open chan struct{}, reads *int32) []*gatedStore {
+	now := time.Now().UTC().Truncate(time.Hour)
+	stores := make([]*gatedStore, n)
+
+	for i := range stores {
+		at := now.Add(time.Duration(i) * time.Minute)
+		record := nsgpeektest.FlowLogRecord(testNsgId, at, testMac, "UserRule_ssh", nsgpeektest.FlowTuple(at, "10.0.0.1", "10.0.0.5", 50000, 22))
+		stores[i] = &gatedStore{blocks: nsgpeektest.FlowLogBlocks(record), gate: open, reads: reads}
+	}
+
+	return stores
+}
+
+func TestSearchWorkers(t *testing.T) {
+	t.Run("LimitsBlobsReadAheadOfSlowBlob", func(t *testing.T) {
+		const blobCount, workers, window = 10, 2, 3
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var reads int32
+		open := make(chan struct{})
+		close(open)
+
+		stores := testStores(blobCount, open, &reads)
+		slow := make(chan struct{})
+		stores[0].gate = slow
+
+		mergedCh, errCh := runSearchWorkers(ctx, stores, workers, window)
+
+		// the merge is stuck on the first blob, so only the blobs in the window should be read
+		time.Sleep(time.Millisecond * 300)
+		if n := atomic.LoadInt32(&reads); n > window {
+			t.Fatalf("read %v blobs ahead of the merge, want at most %v", n, window)
+		}
+
+		close(slow)
+
+		if got := mergedTuples(t, mergedCh, errCh); got != blobCount {
+			t.Errorf("unexpected number of tuples merged. want: %v, got: %v", blobCount, got)
+		}
+	})
+
+	t.Run("UndecodableBlobDoesntHoldUpTheMerge", func(t *testing.T) {
+		const blobCount, workers, window = 6, 2, 2
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var reads int32
+		open := make(chan struct{})
+		close(open)
+
+		stores := testStores(blobCount, open, &reads)
+		stores[0].blocks[1] = []byte(`{"records": not json`)
+
+		mergedCh, errCh := runSearchWorkers(ctx, stores, workers, window)
+
+		select {
+		case err := <-errCh:
+			if err == nil {
+				t.Fatal("expected an error decoding the first blob")
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("expected an error decoding the first blob")
+		}
+
+		// the failed blob gives up its place in the window and closes its input, so the rest still merge
+		if got := mergedTuples(t, mergedCh, errCh); got != blobCount-1 {
+			t.Errorf("unexpected number of tuples merged. want: %v, got: %v", blobCount-1, got)
+		}
+	})
+
+	t.Run("StopsWhenCancelled", func(t *testing.T) {
+		const blobCount, workers, window = 10, 2, 4
+
+		before := runtime.NumGoroutine()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var reads int32
+		open := make(chan struct{})
+		close(open)
+
+		// nothing reads the merged tuples, so every goroutine ends up blocked sending them on
+		runSearchWorkers(ctx, testStores(blobCount, open, &reads), workers, window)
+		waitFor(t, func() bool { return atomic.LoadInt32(&reads) >= window })
+
+		cancel()
+		waitFor(t, func() bool { return runtime.NumGoroutine() <= before })
+	})
+}
+
+// mergedTuples counts the tuples merged until the merge is done, failing on any error.
+func mergedTuples(t *testing.T, mergedCh <-chan []flowlog.FlowTuple, errCh <-chan error) int {
+	var got int
+	for {
+		select {
+		case tuples, ok := <-mergedCh:
+			if !ok {
+				return got
+			}
+			got += len(tuples)
+		case err := <-errCh:
+			t.Fatalf("unexpected error: %v", err)
+		case <-time.After(time.Second * 5):
+			t.Fatal("search didn't finish")
+		}
+	}
+}
+
+func TestRejectsInvalidMaxAttempts(t *testing.T) {
+	for _, attempts := range []int{0, -1} {
+		args := commonArgs{NsgName: []string{"nsg-test"}, MaxAttempts: attempts}
+
+		t.Run(fmt.Sprintf("Search%v", attempts), func(t *testing.T) {
+			cmd := SearchCmd{commonArgs: args, Parallelism: 1, BlobTimeout: time.Second}
+			if err := cmd.Run(&cliContext{ctx: context.Background()}); err == nil || !strings.Contains(err.Error(), "max attempts") {
+				t.Errorf("expected a max attempts error, got %v", err)
+			}
+		})
+
+		t.Run(fmt.Sprintf("Stream%v", attempts), func(t *testing.T) {
+			cmd := StreamCmd{commonArgs: args}
+			if err := cmd.Run(&cliContext{ctx: context.Background()}); err == nil || !strings.Contains(err.Error(), "max attempts") {
+				t.Errorf("expected a max attempts error, got %v", err)
+			}
+		})
+	}
+}
@@ -2,66 +2,118 @@ package cli
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"sort"
 	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/tmeadon/nsgpeek/pkg/azure"
 	"github.com/tmeadon/nsgpeek/pkg/blobreader"
+	"github.com/tmeadon/nsgpeek/pkg/checkpoint"
+	"github.com/tmeadon/nsgpeek/pkg/flowwriter"
 	"github.com/tmeadon/nsgpeek/pkg/logblobfinder"
 )
 
 type StreamCmd struct {
 	commonArgs
+	Checkpoint string        `xor:"start" type:"path" help:"(Optional) File to record progress through each blob in, a restarted stream carries on from where the last one stopped"`
+	Since      time.Duration `xor:"start" help:"(Optional) Replay flows from this long ago, e.g. 15m, before following new ones"`
+}
+
+var (
+	// streamPollInterval is how often the blob being streamed is checked for new blocks
+	streamPollInterval = time.Second * 5
+	// findLatestInterval is how often each flow log is checked for a newer blob
+	findLatestInterval = time.Second * 10
+	// streamDrainGrace is how long a superseded blob is followed after it last changed
+	streamDrainGrace = blobreader.DefaultDrainGrace
+)
+
+// sourceBlobs are the latest blobs found by one of the finders being streamed from, one for each NIC.
+type sourceBlobs struct {
+	source int
+	blobs  []*azure.Blob
 }
 
 func (s *StreamCmd) Run(ctx *cliContext) error {
-	log.Print("creating blob finder")
-	finder, err := logblobfinder.NewLogBlobFinder(subs, s.NsgName, context.Background(), cred)
-	if err != nil {
-		return err
+	if s.Since < 0 {
+		return fmt.Errorf("since must not be negative, got %v", s.Since)
 	}
 
-	log.Print("preparing chans")
+	if s.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, got %v", s.MaxAttempts)
+	}
 
-	blobCh := make(chan (*azure.Blob))
-	dataCh := make(chan ([][]byte))
-	streamStopCh := make(chan (bool))
-	errCh := make(chan (error))
+	// the checkpoint moves on once flows have been received, so flows still held in the sort window
+	// would be lost if the stream stopped without closing the writers
+	if s.SortWindow > 0 && s.Checkpoint != "" {
+		return errors.New("sort window can't be used with a checkpoint")
+	}
 
-	log.Print("starting spinner")
+	since := time.Now().UTC().Add(-s.Since)
 
-	// spin := spinner.New(spinner.CharSets[43], 100*time.Millisecond)
-	// spin.Prefix = "finding latest nsg flow blob...  "
-	// spin.Start()
+	log.Print("creating blob finders")
+	finders, err := getLogBlobFinders(ctx.ctx, s.commonArgs)
+	if err != nil {
+		return err
+	}
 
-	log.Print("finding latest")
+	log.Print("preparing chans")
 
-	var blob *azure.Blob
-	go finder.FindLatest(blobCh, errCh, time.Second*10)
+	blobCh := make(chan sourceBlobs)
+	st := newStreamer(s.retryPolicy())
 
-	log.Print("stopping spinner")
-	// spin.Stop()
+	if s.Checkpoint != "" {
+		if st.checkpoint, err = checkpoint.Load(s.Checkpoint); err != nil {
+			return err
+		}
 
-	select {
-	case err := <-errCh:
-		return err
-	case blob = <-blobCh:
+		log.Print("catching up from checkpoint")
+		if err := st.catchUp(ctx.ctx, finders); err != nil {
+			return err
+		}
 	}
 
-	log.Print("creating blob reader")
-
-	blobReader := blobreader.NewBlobReader(blob, dataCh, errCh)
-	go blobReader.Stream(streamStopCh, time.Second*5)
+	if s.Since > 0 {
+		log.Printf("replaying flows since %v", since)
+		if err := st.replay(ctx.ctx, finders, since); err != nil {
+			return err
+		}
+	}
 
+	// the writers are created once nothing else can fail so that an output file isn't created or
+	// truncated by a stream that never starts
 	log.Print("creating writer group")
 
-	writers, err := initWriterGroup(s.commonArgs)
+	var filters []flowwriter.Filter
+
+	// blobs hold a whole hour so tuples from before the replay starts are read too
+	if s.Since > 0 {
+		filters = append(filters, flowwriter.NewTimeFilter(since, time.Time{}))
+	}
+
+	writers, err := initWriterGroup(s.commonArgs, filters...)
 	if err != nil {
 		return err
 	}
 
-	spin := spinner.New(spinner.CharSets[43], 100*time.Millisecond)
+	// the latest blobs are only followed once the backlog has been written so that flows from earlier
+	// hours don't come out mixed up with the live ones
+	readBacklog := st.readBacklog(ctx.ctx)
+
+	go func() {
+		readBacklog()
+
+		log.Print("finding latest")
+		for i, f := range finders {
+			go findLatest(ctx.ctx, i, f, blobCh, st.errCh)
+		}
+	}()
+
+	spin := spinner.New(spinner.CharSets[43], 100*time.Millisecond, spinner.WithWriter(os.Stderr))
 	spin.Prefix = "waiting for nsg logs...  "
 
 	for {
@@ -69,21 +121,460 @@ func (s *StreamCmd) Run(ctx *cliContext) error {
 		log.Print("starting loop")
 
 		select {
-		case blob := <-blobCh:
-			streamStopCh <- true
-			blobReader = blobreader.NewBlobReader(blob, dataCh, errCh)
-			go blobReader.Stream(streamStopCh, time.Second*5)
+		case sb := <-blobCh:
+			st.update(ctx.ctx, sb)
 
-		case data := <-dataCh:
+		case data := <-st.dataCh:
 			spin.Stop()
 			for _, d := range data {
-				writers.WriteFlowBlock(d)
+				if err := writers.WriteFlowBlock(d); err != nil {
+					writers.Close()
+					return err
+				}
+			}
+			// with a sort window the writers write flows as they leave it, otherwise they hold every
+			// flow until they are flushed
+			if s.SortWindow == 0 {
+				if err := writers.Flush(); err != nil {
+					writers.Close()
+					return err
+				}
 			}
-			writers.Flush()
 			spin.Start()
 
-		case err := <-errCh:
-			log.Fatalf("error encountered: %v", err)
+		case pos := <-st.posCh:
+			if err := st.record(pos); err != nil {
+				spin.Stop()
+				writers.Close()
+				return err
+			}
+
+		case key := <-st.doneCh:
+			if err := st.forget(key); err != nil {
+				spin.Stop()
+				writers.Close()
+				return err
+			}
+
+		case err := <-st.errCh:
+			spin.Stop()
+			writers.Close()
+			return fmt.Errorf("error encountered: %w", err)
+
+		case <-ctx.ctx.Done():
+			spin.Stop()
+			return writers.Close()
+		}
+	}
+}
+
+// streamer runs a reader for each of the latest blobs found for every source, all sending their blocks
+// to dataCh.  With a checkpoint it also records how far through each blob the blocks written have got.
+type streamer struct {
+	retry  azure.RetryPolicy
+	dataCh chan [][]byte
+	errCh  chan error
+	posCh  chan blobreader.Position
+	// doneCh receives the checkpoint key of each blob once it has been read to its end
+	doneCh chan string
+	// checkpoint is nil unless --checkpoint is given
+	checkpoint *checkpoint.Checkpoint
+	// readers hold the stop channel of each reader, keyed by source and then by checkpoint key.  The
+	// channels are buffered so that stopping a reader blocked sending data can't hold up the loop
+	readers map[int]map[string]chan bool
+	// resume holds the blobs a source's first readers start part way through when resuming
+	resume map[int]map[string]resumeBlob
+	// started records the sources whose first blobs have been found
+	started map[int]bool
+	// paths holds the path of each blob whose position is being recorded, by checkpoint key
+	paths map[string]string
+	// backlog holds the blobs from before each source's newest hour that are read, one after another in
+	// time order, before the stream starts following the latest blobs
+	backlog []backlogBlob
+}
+
+type resumeBlob struct {
+	blob   *azure.Blob
+	offset int64
+}
+
+type backlogBlob struct {
+	resumeBlob
+	start time.Time
+}
+
+func newStreamer(retry azure.RetryPolicy) *streamer {
+	return &streamer{
+		retry:   retry,
+		dataCh:  make(chan [][]byte),
+		errCh:   make(chan error),
+		posCh:   make(chan blobreader.Position),
+		doneCh:  make(chan string),
+		readers: make(map[int]map[string]chan bool),
+		resume:  make(map[int]map[string]resumeBlob),
+		started: make(map[int]bool),
+		paths:   make(map[string]string),
+	}
+}
+
+// catchUp replays what each source wrote while the stream was stopped, working from the blobs the source
+// has in the checkpoint.  Sources with nothing in the checkpoint start from the end as usual, and entries
+// for blobs that none of the sources write are dropped so that they can't pull a later catch up back.
+func (st *streamer) catchUp(ctx context.Context, finders []*logblobfinder.Finder) error {
+	listed := make(map[string]bool)
+
+	for i, f := range finders {
+		start, ok := st.checkpointStart(f)
+		if !ok {
+			continue
+		}
+
+		blobs, err := f.FindSpecific(start, time.Now().UTC())
+		if errors.Is(err, logblobfinder.ErrBlobPrefixNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find blobs written since %v: %w", start, err)
+		}
+
+		for j := range blobs {
+			listed[checkpoint.Key(blobs[j].URL())] = true
+		}
+
+		pending, err := st.unread(blobs)
+		if err != nil {
+			return err
+		}
+
+		if err := st.schedule(i, pending); err != nil {
+			return err
+		}
+	}
+
+	for key, p := range st.checkpoint.Blobs {
+		if listed[key] {
+			continue
+		}
+
+		log.Printf("dropping checkpointed blob %v as it isn't being streamed", p.Path)
+		if err := st.checkpoint.Remove(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkpointStart returns the start of the oldest hour with a blob from the finder's NSG in the checkpoint.
+func (st *streamer) checkpointStart(f *logblobfinder.Finder) (time.Time, bool) {
+	var start time.Time
+
+	for _, p := range st.checkpoint.Blobs {
+		if !f.HasBlob(p.Path) {
+			continue
+		}
+
+		t, err := logblobfinder.BlobStartTime(p.Path)
+		if err != nil {
+			log.Printf("ignoring checkpointed blob %v: %v", p.Path, err)
+			continue
+		}
+		if start.IsZero() || t.Before(start) {
+			start = t
+		}
+	}
+
+	return start, !start.IsZero()
+}
+
+// unread returns the parts of a source's blobs that haven't been written yet.  Checkpointed blobs carry
+// on from their offset and a NIC's later blobs are read from the start.  NICs missing from the checkpoint
+// weren't being followed when it was saved, so only their blobs from the newest checkpointed hour on are
+// read.  Nothing is returned for a source without any blobs in the checkpoint.
+func (st *streamer) unread(blobs []azure.Blob) ([]resumeBlob, error) {
+	starts := make([]time.Time, len(blobs))
+	// oldest holds the oldest checkpointed hour of each NIC
+	oldest := make(map[string]time.Time)
+	var newest time.Time
+
+	for i := range blobs {
+		t, err := logblobfinder.BlobStartTime(blobs[i].Path)
+		if err != nil {
+			return nil, err
+		}
+		starts[i] = t
+
+		if _, ok := st.checkpoint.Offset(checkpoint.Key(blobs[i].URL())); !ok {
+			continue
+		}
+
+		mac := logblobfinder.BlobMac(blobs[i].Path)
+		if o, ok := oldest[mac]; !ok || t.Before(o) {
+			oldest[mac] = t
+		}
+		if t.After(newest) {
+			newest = t
+		}
+	}
+
+	if len(oldest) == 0 {
+		return nil, nil
+	}
+
+	var pending []resumeBlob
+
+	for i := range blobs {
+		offset, ok := st.checkpoint.Offset(checkpoint.Key(blobs[i].URL()))
+
+		if !ok {
+			from, found := oldest[logblobfinder.BlobMac(blobs[i].Path)]
+			if !found {
+				from = newest
+			}
+			if starts[i].Before(from) {
+				continue
+			}
+		}
+
+		pending = append(pending, resumeBlob{&blobs[i], offset})
+	}
+
+	return pending, nil
+}
+
+// replay reads everything each source has written since start.
+func (st *streamer) replay(ctx context.Context, finders []*logblobfinder.Finder, start time.Time) error {
+	for i, f := range finders {
+		blobs, err := f.FindSpecific(start, time.Now().UTC())
+		if errors.Is(err, logblobfinder.ErrBlobPrefixNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find blobs written since %v: %w", start, err)
+		}
+
+		pending := make([]resumeBlob, len(blobs))
+		for j := range blobs {
+			pending[j] = resumeBlob{blob: &blobs[j]}
+		}
+
+		if err := st.schedule(i, pending); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// schedule adds a source's pending blobs from before the newest hour to the backlog and leaves the newest
+// hour's for the source's first readers to start from, so that they carry on following them without
+// reading anything twice.
+func (st *streamer) schedule(source int, pending []resumeBlob) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	starts := make([]time.Time, len(pending))
+	var newest time.Time
+
+	for i, r := range pending {
+		t, err := logblobfinder.BlobStartTime(r.blob.Path)
+		if err != nil {
+			return err
+		}
+		starts[i] = t
+		if t.After(newest) {
+			newest = t
+		}
+	}
+
+	st.resume[source] = make(map[string]resumeBlob)
+
+	for i, r := range pending {
+		if starts[i].Before(newest) {
+			st.backlog = append(st.backlog, backlogBlob{r, starts[i]})
+		} else {
+			st.resume[source][checkpoint.Key(r.blob.URL())] = r
+		}
+	}
+
+	return nil
+}
+
+// readBacklog returns a function that reads the backlog one blob at a time, oldest first, so that
+// replayed flows are written in order.  The function returns once every blob has been read or ctx is
+// cancelled.
+func (st *streamer) readBacklog(ctx context.Context) func() {
+	sort.SliceStable(st.backlog, func(i, j int) bool { return st.backlog[i].start.Before(st.backlog[j].start) })
+
+	reads := make([]func() bool, len(st.backlog))
+	for i, b := range st.backlog {
+		reads[i] = st.reader(ctx, b.blob, b.offset)
+	}
+	st.backlog = nil
+
+	return func() {
+		for _, read := range reads {
+			if !read() {
+				return
+			}
+		}
+	}
+}
+
+// update starts a reader for each blob that isn't already being streamed and stops the readers of blobs
+// that are no longer among the latest.  Azure can still commit the last records of an hour after the next
+// hour's blob appears, so stopped readers keep draining their blob until it stops changing.
+func (st *streamer) update(ctx context.Context, sb sourceBlobs) {
+	current := st.readers[sb.source]
+	next := make(map[string]chan bool)
+
+	resume, resuming := st.resume[sb.source]
+	delete(st.resume, sb.source)
+
+	// blobs that appear after the first are read from the start so that nothing written before they
+	// were found is missed
+	fromStart := st.started[sb.source] || resuming
+	st.started[sb.source] = true
+
+	for _, b := range sb.blobs {
+		key := checkpoint.Key(b.URL())
+
+		if stop, ok := current[key]; ok {
+			next[key] = stop
+			delete(current, key)
+			continue
+		}
+
+		log.Printf("creating blob reader for %v", b.Path)
+
+		stopCh := make(chan bool, 1)
+		next[key] = stopCh
+		reader := st.newReader(b, key)
+
+		if r, ok := resume[key]; ok {
+			delete(resume, key)
+			st.follow(ctx, key, func() { reader.StreamFrom(ctx, stopCh, r.offset, streamPollInterval) })
+		} else if fromStart {
+			st.follow(ctx, key, func() { reader.StreamFrom(ctx, stopCh, 0, streamPollInterval) })
+		} else {
+			st.follow(ctx, key, func() { reader.Stream(ctx, stopCh, streamPollInterval) })
+		}
+	}
+
+	st.readers[sb.source] = next
+
+	for key, stopCh := range current {
+		log.Printf("draining blob reader for %v", key)
+		stopCh <- true
+	}
+
+	// blobs from the checkpoint that were superseded before the stream found them still need reading
+	for _, r := range resume {
+		st.readFrom(ctx, r.blob, r.offset)
+	}
+}
+
+// follow runs stream, which returns once its reader has been stopped and drained, and then sends key
+// to doneCh.
+func (st *streamer) follow(ctx context.Context, key string, stream func()) {
+	go func() {
+		stream()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case st.doneCh <- key:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (st *streamer) newReader(b *azure.Blob, key string) *blobreader.BlobReader {
+	b.Retry = st.retry
+	reader := blobreader.NewBlobReader(b, st.dataCh, st.errCh)
+	reader.DrainFor(streamDrainGrace)
+
+	if st.checkpoint != nil {
+		st.paths[key] = b.Path
+		reader.ReportPositions(key, st.posCh)
+	}
+
+	return reader
+}
+
+// readFrom reads a blob from offset to its end in the background, sending its key to doneCh once it
+// has been read.
+func (st *streamer) readFrom(ctx context.Context, b *azure.Blob, offset int64) {
+	read := st.reader(ctx, b, offset)
+	go read()
+}
+
+// reader returns a function that reads a blob from offset to its end and sends its key to doneCh,
+// returning false if ctx is cancelled first.
+func (st *streamer) reader(ctx context.Context, b *azure.Blob, offset int64) func() bool {
+	key := checkpoint.Key(b.URL())
+	reader := st.newReader(b, key)
+
+	return func() bool {
+		log.Printf("catching up on %v from offset %v", b.Path, offset)
+
+		doneCh := make(chan bool)
+		go reader.ReadFrom(ctx, offset, doneCh)
+
+		select {
+		case <-doneCh:
+			select {
+			case st.doneCh <- key:
+				return true
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
+		}
+
+		return false
+	}
+}
+
+// record saves a reader's position to the checkpoint.  The data before it has already been written
+// because readers only send their position once the loop has received the data.
+func (st *streamer) record(pos blobreader.Position) error {
+	path, ok := st.paths[pos.Key]
+	if !ok {
+		// the blob has been read to its end since
+		return nil
+	}
+
+	return st.checkpoint.Set(pos.Key, path, pos.Offset)
+}
+
+// forget removes a blob that is no longer being read from the checkpoint.
+func (st *streamer) forget(key string) error {
+	if st.checkpoint == nil {
+		return nil
+	}
+
+	delete(st.paths, key)
+	return st.checkpoint.Remove(key)
+}
+
+// findLatest sends the latest blobs found by a finder to blobCh, tagged with the finder's source index.
+func findLatest(ctx context.Context, source int, finder *logblobfinder.Finder, blobCh chan<- sourceBlobs, errCh chan error) {
+	ch := make(chan []*azure.Blob)
+	go finder.FindLatest(ctx, ch, errCh, findLatestInterval)
+
+	for {
+		select {
+		case b := <-ch:
+			select {
+			case blobCh <- sourceBlobs{source, b}:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
 		}
 	}
 }
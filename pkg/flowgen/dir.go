@@ -0,0 +1,28 @@
+package flowgen
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteDir writes a blob to its path under dir, as it would be found in a download of the flow log
+// container, and sets its modified time to that of the blob.
+func WriteDir(dir string, b Blob) error {
+	dest := filepath.Join(dir, filepath.FromSlash(b.Path))
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %v: %w", b.Path, err)
+	}
+
+	if err := os.WriteFile(dest, bytes.Join(b.Blocks, nil), 0o644); err != nil {
+		return fmt.Errorf("failed to write %v: %w", b.Path, err)
+	}
+
+	if err := os.Chtimes(dest, b.LastModified, b.LastModified); err != nil {
+		return fmt.Errorf("failed to set modified time of %v: %w", b.Path, err)
+	}
+
+	return nil
+}
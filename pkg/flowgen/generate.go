@@ -0,0 +1,291 @@
+package flowgen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Config describes the traffic a Generator produces flow logs for.
+type Config struct {
+	// NsgId is the resource ID of the NSG the logs appear to come from
+	NsgId string
+	// Macs are the MAC addresses of the NICs behind the NSG, each NIC gets its own blob every hour
+	Macs []string
+	// Version is the flow log format version, 1 or 2
+	Version int
+	// Rate is the number of flows logged per minute for each NIC
+	Rate int
+	// DenyRatio is the fraction of flows denied by DenyRule, the rest are allowed by one of AllowRules
+	DenyRatio  float64
+	AllowRules []string
+	DenyRule   string
+	// SrcPool and DstPool are the prefixes flow source and destination addresses are picked from
+	SrcPool  []netip.Prefix
+	DstPool  []netip.Prefix
+	DstPorts []int
+}
+
+// Blob is a generated PT1H.json blob, split into blocks the way Azure writes them: an opening block, a
+// block for each record and a closing block.
+type Blob struct {
+	Path   string
+	Blocks [][]byte
+	// LastModified is the time of the blob's last record
+	LastModified time.Time
+}
+
+type Generator struct {
+	cfg  Config
+	rand *rand.Rand
+}
+
+// NewGenerator returns a generator for cfg whose output is the same each time for a given seed.
+func NewGenerator(cfg Config, seed int64) (*Generator, error) {
+	switch {
+	case cfg.NsgId == "":
+		return nil, errors.New("an nsg resource id is required")
+	case len(cfg.Macs) == 0:
+		return nil, errors.New("at least one mac address is required")
+	case cfg.Version != 1 && cfg.Version != 2:
+		return nil, fmt.Errorf("unsupported flow log version %v, expected 1 or 2", cfg.Version)
+	case cfg.Rate < 1:
+		return nil, fmt.Errorf("rate must be at least 1, got %v", cfg.Rate)
+	case cfg.DenyRatio < 0 || cfg.DenyRatio > 1:
+		return nil, fmt.Errorf("deny ratio must be between 0 and 1, got %v", cfg.DenyRatio)
+	case len(cfg.AllowRules) == 0 && cfg.DenyRatio < 1:
+		return nil, errors.New("at least one allow rule is required unless every flow is denied")
+	case len(cfg.SrcPool) == 0 || len(cfg.DstPool) == 0:
+		return nil, errors.New("source and destination address pools are required")
+	case len(cfg.DstPorts) == 0:
+		return nil, errors.New("at least one destination port is required")
+	}
+
+	for _, p := range cfg.DstPorts {
+		if p < 0 || p > 65535 {
+			return nil, fmt.Errorf("destination port %v is not between 0 and 65535", p)
+		}
+	}
+
+	return &Generator{cfg: cfg, rand: rand.New(rand.NewSource(seed))}, nil
+}
+
+// RandomMacs returns n MAC addresses with the prefix Azure uses for NICs.
+func RandomMacs(n int, seed int64) []string {
+	r := rand.New(rand.NewSource(seed))
+	macs := make([]string, n)
+
+	for i := range macs {
+		macs[i] = fmt.Sprintf("000D3A%06X", r.Intn(1<<24))
+	}
+
+	return macs
+}
+
+// Generate calls fn with a blob for each NIC for every hour from start to end.  Each blob holds a record
+// for every minute of the hour within that range.
+func (g *Generator) Generate(start time.Time, end time.Time, fn func(Blob) error) error {
+	start = start.UTC().Truncate(time.Minute)
+	end = end.UTC()
+
+	for hour := start.Truncate(time.Hour); hour.Before(end); hour = hour.Add(time.Hour) {
+		from, to := hour, hour.Add(time.Hour)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+
+		for _, mac := range g.cfg.Macs {
+			b, err := g.blob(hour, from, to, mac)
+			if err != nil {
+				return err
+			}
+			if err := fn(b); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (g *Generator) blob(hour time.Time, from time.Time, to time.Time, mac string) (Blob, error) {
+	b := Blob{
+		Path:   BlobPath(g.cfg.NsgId, hour, mac),
+		Blocks: [][]byte{[]byte(`{"records":[`)},
+	}
+
+	for minute := from; minute.Before(to); minute = minute.Add(time.Minute) {
+		// records are written at the end of the minute they cover
+		recordTime := minute.Add(time.Minute - time.Millisecond)
+		data, err := g.record(minute, recordTime, mac)
+		if err != nil {
+			return Blob{}, err
+		}
+
+		if len(b.Blocks) > 1 {
+			data = append([]byte(","), data...)
+		}
+
+		b.Blocks = append(b.Blocks, data)
+		b.LastModified = recordTime
+	}
+
+	b.Blocks = append(b.Blocks, []byte("]}"))
+	return b, nil
+}
+
+// BlobPath returns the path, relative to the flow log container, of the blob holding an NSG's logs for
+// the hour starting at hour for the NIC with the given MAC address.
+func BlobPath(nsgId string, hour time.Time, mac string) string {
+	hour = hour.UTC()
+	return fmt.Sprintf("resourceId=%v/y=%04d/m=%02d/d=%02d/h=%02d/m=00/macAddress=%v/PT1H.json",
+		strings.ToUpper(strings.TrimSuffix(nsgId, "/")), hour.Year(), hour.Month(), hour.Day(), hour.Hour(), mac)
+}
+
+type record struct {
+	Time          string           `json:"time"`
+	SystemId      string           `json:"systemId"`
+	MacAddress    string           `json:"macAddress"`
+	Category      string           `json:"category"`
+	ResourceId    string           `json:"resourceId"`
+	OperationName string           `json:"operationName"`
+	Properties    recordProperties `json:"properties"`
+}
+
+type recordProperties struct {
+	Version int         `json:"Version"`
+	Flows   []ruleFlows `json:"flows"`
+}
+
+type ruleFlows struct {
+	Rule  string     `json:"rule"`
+	Flows []macFlows `json:"flows"`
+}
+
+type macFlows struct {
+	Mac        string   `json:"mac"`
+	FlowTuples []string `json:"flowTuples"`
+}
+
+// record returns a record holding the flows seen by a NIC in the minute starting at minute.
+func (g *Generator) record(minute time.Time, recordTime time.Time, mac string) ([]byte, error) {
+	var rules []string
+	tuples := make(map[string][]string)
+
+	for i := 0; i < g.cfg.Rate; i++ {
+		rule, tuple := g.tuple(minute.Add(time.Duration(g.rand.Int63n(int64(time.Minute)))))
+
+		if _, ok := tuples[rule]; !ok {
+			rules = append(rules, rule)
+		}
+		tuples[rule] = append(tuples[rule], tuple)
+	}
+
+	r := record{
+		Time:          recordTime.Format(time.RFC3339Nano),
+		SystemId:      "e79aab03-ffb0-4419-8a28-90be262a7028",
+		MacAddress:    mac,
+		Category:      "NetworkSecurityGroupFlowEvent",
+		ResourceId:    strings.ToUpper(g.cfg.NsgId),
+		OperationName: "NetworkSecurityGroupFlowEvents",
+		Properties:    recordProperties{Version: g.cfg.Version},
+	}
+
+	for _, rule := range rules {
+		r.Properties.Flows = append(r.Properties.Flows, ruleFlows{Rule: rule, Flows: []macFlows{{Mac: mac, FlowTuples: tuples[rule]}}})
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode record for %v: %w", recordTime.Format(time.RFC3339), err)
+	}
+
+	return data, nil
+}
+
+// tuple returns a random flow starting at t along with the rule that decided it.
+func (g *Generator) tuple(t time.Time) (string, string) {
+	rule, decision := g.cfg.DenyRule, "D"
+	if g.rand.Float64() >= g.cfg.DenyRatio {
+		rule, decision = g.cfg.AllowRules[g.rand.Intn(len(g.cfg.AllowRules))], "A"
+	}
+
+	protocol := "T"
+	if g.rand.Intn(5) == 0 {
+		protocol = "U"
+	}
+
+	direction := "I"
+	if g.rand.Intn(2) == 0 {
+		direction = "O"
+	}
+
+	tuple := fmt.Sprintf("%v,%v,%v,%v,%v,%v,%v,%v",
+		t.Unix(),
+		g.addr(g.cfg.SrcPool),
+		g.addr(g.cfg.DstPool),
+		49152+g.rand.Intn(16384),
+		g.cfg.DstPorts[g.rand.Intn(len(g.cfg.DstPorts))],
+		protocol,
+		direction,
+		decision,
+	)
+
+	if g.cfg.Version == 2 {
+		tuple += "," + g.flowState(decision)
+	}
+
+	return rule, tuple
+}
+
+// flowState returns the state and traffic counters of a version 2 tuple.  Denied flows and flows that
+// have only just begun don't have counters.
+func (g *Generator) flowState(decision string) string {
+	if decision == "D" {
+		return "B,,,,"
+	}
+
+	state := []string{"B", "C", "E"}[g.rand.Intn(3)]
+	if state == "B" {
+		return "B,,,,"
+	}
+
+	srcPackets, dstPackets := 1+g.rand.Intn(100), 1+g.rand.Intn(100)
+	return fmt.Sprintf("%v,%v,%v,%v,%v", state, srcPackets, srcPackets*(60+g.rand.Intn(1400)), dstPackets, dstPackets*(60+g.rand.Intn(1400)))
+}
+
+// addr returns a random address from one of the prefixes in pool.
+func (g *Generator) addr(pool []netip.Prefix) netip.Addr {
+	p := pool[g.rand.Intn(len(pool))].Masked()
+
+	hostBits := p.Addr().BitLen() - p.Bits()
+	if hostBits > 62 {
+		hostBits = 62
+	}
+
+	return addOffset(p.Addr(), uint64(g.rand.Int63n(int64(1)<<hostBits)))
+}
+
+// addOffset adds n to the address a.
+func addOffset(a netip.Addr, n uint64) netip.Addr {
+	b := a.As16()
+
+	for i := len(b) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(b[i]) + n&0xff
+		b[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+
+	addr := netip.AddrFrom16(b)
+	if a.Is4() {
+		return addr.Unmap()
+	}
+	return addr
+}
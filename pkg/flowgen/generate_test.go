@@ -0,0 +1,204 @@
+package flowgen
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+	"github.com/tmeadon/nsgpeek/pkg/logblobfinder"
+)
+
+const testNsgId = "/subscriptions/xyz/resourceGroups/rg/providers/Microsoft.Network/networkSecurityGroups/nsg-view"
+
+func testConfig() Config {
+	return Config{
+		NsgId:      testNsgId,
+		Macs:       []string{"000D3AD488D1", "000D3AD488D2"},
+		Version:    2,
+		Rate:       10,
+		DenyRatio:  0.2,
+		AllowRules: []string{"UserRule_https"},
+		DenyRule:   "DefaultRule_DenyAllInBound",
+		SrcPool:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+		DstPool:    []netip.Prefix{netip.MustParsePrefix("192.168.1.0/30"), netip.MustParsePrefix("fd00::/120")},
+		DstPorts:   []int{443},
+	}
+}
+
+func generate(t *testing.T, cfg Config, start time.Time, end time.Time) []Blob {
+	g, err := NewGenerator(cfg, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var blobs []Blob
+	if err := g.Generate(start, end, func(b Blob) error { blobs = append(blobs, b); return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return blobs
+}
+
+// parseTuples parses every record block in a blob, skipping the opening and closing blocks as the blob
+// reader does.
+func parseTuples(t *testing.T, b Blob) []flowlog.FlowTuple {
+	var tuples []flowlog.FlowTuple
+
+	for _, data := range b.Blocks[1 : len(b.Blocks)-1] {
+		fb, err := flowlog.ParseBlock(data)
+		if err != nil {
+			t.Fatalf("failed to parse block %q: %v", data, err)
+		}
+		tuples = append(tuples, fb.Tuples()...)
+	}
+
+	return tuples
+}
+
+func TestGenerate(t *testing.T) {
+	start := time.Date(2022, 8, 9, 10, 30, 0, 0, time.UTC)
+	end := time.Date(2022, 8, 9, 12, 0, 0, 0, time.UTC)
+
+	t.Run("WritesABlobPerNicPerHour", func(t *testing.T) {
+		blobs := generate(t, testConfig(), start, end)
+
+		if len(blobs) != 4 {
+			t.Fatalf("unexpected number of blobs. want: 4, got: %v", len(blobs))
+		}
+
+		var got []string
+		for _, b := range blobs {
+			got = append(got, b.Path)
+		}
+
+		var want []string
+		for _, hour := range []time.Time{start.Truncate(time.Hour), end.Add(-time.Hour)} {
+			for _, mac := range testConfig().Macs {
+				want = append(want, BlobPath(testNsgId, hour, mac))
+			}
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected blob paths. want: %v, got: %v", want, got)
+		}
+
+		// paths must be in the layout the finders search
+		if hour, err := logblobfinder.BlobStartTime(got[0]); err != nil || !hour.Equal(start.Truncate(time.Hour)) {
+			t.Errorf("unexpected start time %v for blob %v: %v", hour, got[0], err)
+		}
+	})
+
+	t.Run("BlobsAreValidJson", func(t *testing.T) {
+		for _, b := range generate(t, testConfig(), start, end) {
+			var records struct{ Records []interface{} }
+			if err := json.Unmarshal(bytes.Join(b.Blocks, nil), &records); err != nil {
+				t.Fatalf("blob %v is not valid json: %v", b.Path, err)
+			}
+		}
+	})
+
+	t.Run("WritesARecordForEachMinuteAtTheRate", func(t *testing.T) {
+		blobs := generate(t, testConfig(), start, end)
+
+		// the first hour starts half way through
+		if n := len(blobs[0].Blocks) - 2; n != 30 {
+			t.Errorf("unexpected number of records in first hour. want: 30, got: %v", n)
+		}
+		if n := len(blobs[2].Blocks) - 2; n != 60 {
+			t.Errorf("unexpected number of records in second hour. want: 60, got: %v", n)
+		}
+
+		if n := len(parseTuples(t, blobs[0])); n != 300 {
+			t.Errorf("unexpected number of tuples. want: 300, got: %v", n)
+		}
+	})
+
+	t.Run("TuplesMatchConfig", func(t *testing.T) {
+		denied := 0
+		tuples := parseTuples(t, generate(t, testConfig(), start, end)[0])
+
+		for _, tp := range tuples {
+			if tp.Version != 2 {
+				t.Fatalf("unexpected tuple version %v", tp.Version)
+			}
+			if !netip.MustParsePrefix("10.0.0.0/24").Contains(tp.SourceAddress) {
+				t.Errorf("source address %v outside of pool", tp.SourceAddress)
+			}
+			if !netip.MustParsePrefix("192.168.1.0/30").Contains(tp.DestAddress) && !netip.MustParsePrefix("fd00::/120").Contains(tp.DestAddress) {
+				t.Errorf("destination address %v outside of pool", tp.DestAddress)
+			}
+			if tp.DestPort != 443 {
+				t.Errorf("unexpected destination port %v", tp.DestPort)
+			}
+			if tp.Time.Before(start) || !tp.Time.Before(start.Add(time.Minute*30)) {
+				t.Errorf("tuple time %v outside of blob's range", tp.Time)
+			}
+
+			if tp.Decision == flowlog.DecisionDeny {
+				denied++
+				if tp.Rule != "DefaultRule_DenyAllInBound" {
+					t.Errorf("denied tuple has rule %v", tp.Rule)
+				}
+			} else if tp.Rule != "UserRule_https" {
+				t.Errorf("allowed tuple has rule %v", tp.Rule)
+			}
+		}
+
+		if ratio := float64(denied) / float64(len(tuples)); ratio < 0.1 || ratio > 0.3 {
+			t.Errorf("unexpected deny ratio %v", ratio)
+		}
+	})
+
+	t.Run("WritesVersion1Tuples", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Version = 1
+
+		for _, tp := range parseTuples(t, generate(t, cfg, start, end)[0]) {
+			if tp.Version != 1 {
+				t.Fatalf("unexpected tuple version %v", tp.Version)
+			}
+		}
+	})
+
+	t.Run("OutputIsTheSameForASeed", func(t *testing.T) {
+		if a, b := generate(t, testConfig(), start, end), generate(t, testConfig(), start, end); !reflect.DeepEqual(a, b) {
+			t.Error("generated different blobs with the same seed")
+		}
+	})
+
+	t.Run("RejectsInvalidConfig", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(cfg *Config)
+		}{
+			{"DenyRatioOverOne", func(cfg *Config) { cfg.DenyRatio = 1.5 }},
+			{"NoDestinationPorts", func(cfg *Config) { cfg.DstPorts = nil }},
+			{"NegativeDestinationPort", func(cfg *Config) { cfg.DstPorts = []int{443, -1} }},
+			{"DestinationPortOverMax", func(cfg *Config) { cfg.DstPorts = []int{65536} }},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				cfg := testConfig()
+				tt.modify(&cfg)
+
+				if _, err := NewGenerator(cfg, 1); err == nil {
+					t.Error("expected an error")
+				}
+			})
+		}
+	})
+
+	t.Run("AcceptsEveryPortInRange", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.DstPorts = []int{0, 65535}
+
+		if _, err := NewGenerator(cfg, 1); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package flowlog
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+)
+
+// Block is a single record from an NSG flow log blob, each record holds the flows logged during one interval.
+type Block struct {
+	Time          time.Time
+	SystemId      string
+	MacAddress    string
+	Category      string
+	ResourceId    string
+	OperationName string
+	Version       int
+	Flows         []FlowGroup
+}
+
+// FlowGroup holds the flows matched by a single rule.
+type FlowGroup struct {
+	Rule  string
+	Flows []Flow
+}
+
+type Flow struct {
+	Mac    string
+	Tuples []FlowTuple
+}
+
+// ParseBlock decodes a block as read from a flow log blob.  Blocks after the first in a blob are
+// prefixed with a comma which is ignored.  If some of the block's tuples are malformed the block is
+// returned without them, together with a *BlockError describing each one.
+func ParseBlock(data []byte) (*Block, error) {
+	// strip the first rune if it's a comma to ensure we have valid json
+	if r, s := utf8.DecodeRune(data); r == rune(',') {
+		data = data[s:]
+	}
+
+	var jb jsonBlock
+	if err := json.Unmarshal(data, &jb); err != nil {
+		return nil, err
+	}
+
+	return jb.block()
+}
+
+// Tuples returns every flow tuple in the block.
+func (b *Block) Tuples() (tuples []FlowTuple) {
+	for _, flowGroup := range b.Flows {
+		for _, flow := range flowGroup.Flows {
+			tuples = append(tuples, flow.Tuples...)
+		}
+	}
+
+	return
+}
+
+type jsonBlock struct {
+	Time          time.Time `json:"time"`
+	SystemId      string    `json:"systemId"`
+	MacAddress    string    `json:"macAddress"`
+	Category      string    `json:"category"`
+	ResourceId    string    `json:"resourceId"`
+	OperationName string    `json:"operationName"`
+	Properties    struct {
+		Version int `json:"Version"`
+		Flows   []struct {
+			Rule  string `json:"rule"`
+			Flows []struct {
+				Mac        string   `json:"mac"`
+				FlowTuples []string `json:"flowTuples"`
+			} `json:"flows"`
+		} `json:"flows"`
+	} `json:"properties"`
+}
+
+func (jb *jsonBlock) block() (*Block, error) {
+	var tupleErrs []error
+
+	b := Block{
+		Time:          jb.Time,
+		SystemId:      jb.SystemId,
+		MacAddress:    jb.MacAddress,
+		Category:      jb.Category,
+		ResourceId:    jb.ResourceId,
+		OperationName: jb.OperationName,
+		Version:       jb.Properties.Version,
+	}
+
+	for _, jg := range jb.Properties.Flows {
+		group := FlowGroup{Rule: jg.Rule}
+
+		for _, jf := range jg.Flows {
+			flow := Flow{Mac: jf.Mac}
+
+			for _, jt := range jf.FlowTuples {
+				t, err := ParseFlowTuple(jt, b.Version)
+				if err != nil {
+					tupleErrs = append(tupleErrs, err)
+					continue
+				}
+
+				t.ResourceId = b.ResourceId
+				t.Mac = jf.Mac
+				t.Rule = jg.Rule
+				flow.Tuples = append(flow.Tuples, t)
+			}
+
+			group.Flows = append(group.Flows, flow)
+		}
+
+		b.Flows = append(b.Flows, group)
+	}
+
+	if len(tupleErrs) > 0 {
+		return &b, &BlockError{tupleErrs}
+	}
+
+	return &b, nil
+}
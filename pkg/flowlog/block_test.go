@@ -0,0 +1,174 @@
+package flowlog
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testBlock string = `,{
+  "time": "2022-08-09T10:03:27.7257644Z",
+  "systemId": "e79aab03-ffb0-4419-8a28-90be262a7028",
+  "macAddress": "000D3AD488D1",
+  "category": "NetworkSecurityGroupFlowEvent",
+  "resourceId": "/SUBSCRIPTIONS/xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx/RESOURCEGROUPS/NSG-VIEW/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW",
+  "operationName": "NetworkSecurityGroupFlowEvents",
+  "properties": {
+    "Version": 2,
+    "flows": [
+      {
+        "rule": "DefaultRule_AllowInternetOutBound",
+        "flows": [
+          {
+            "mac": "000D3AD488D1",
+            "flowTuples": [
+              "1660039344,10.0.0.4,51.104.229.52,50276,443,T,O,A,E,14,2839,15,5801",
+              "1660039344,10.0.0.4,51.105.74.153,47382,443,U,O,D,B,,,,"
+            ]
+          }
+        ]
+      },
+      {
+        "rule": "UserRule_ssh",
+        "flows": [
+          {
+            "mac": "000D3AD488D1",
+            "flowTuples": [
+              "1660039351,38.88.252.187,10.0.0.4,59246,22,T,I,A,C,1,2,3,4"
+            ]
+          }
+        ]
+      }
+    ]
+  }
+}`
+
+func TestParseBlock(t *testing.T) {
+	b, err := ParseBlock([]byte(testBlock))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("KeepsBlockProperties", func(t *testing.T) {
+		if b.Version != 2 || b.MacAddress != "000D3AD488D1" || b.Category != "NetworkSecurityGroupFlowEvent" {
+			t.Errorf("unexpected block properties: %+v", b)
+		}
+
+		if !b.Time.Equal(time.Date(2022, 8, 9, 10, 3, 27, 725764400, time.UTC)) {
+			t.Errorf("unexpected block time: %v", b.Time)
+		}
+	})
+
+	t.Run("ReturnsAllTuples", func(t *testing.T) {
+		if n := len(b.Tuples()); n != 3 {
+			t.Errorf("unexpected number of tuples. want: 3, got: %v", n)
+		}
+	})
+
+	t.Run("ParsesTypedFields", func(t *testing.T) {
+		want := FlowTuple{
+			Time:             time.Unix(1660039344, 0).UTC(),
+			Version:          2,
+			ResourceId:       b.ResourceId,
+			Mac:              "000D3AD488D1",
+			Rule:             "DefaultRule_AllowInternetOutBound",
+			SourceAddress:    netip.MustParseAddr("10.0.0.4"),
+			SourcePort:       50276,
+			DestAddress:      netip.MustParseAddr("51.104.229.52"),
+			DestPort:         443,
+			Protocol:         ProtocolTcp,
+			Direction:        DirectionOutbound,
+			Decision:         DecisionAllow,
+			State:            StateEnd,
+			SrcToDestPackets: 14,
+			SrcToDestBytes:   2839,
+			DestToSrcPackets: 15,
+			DestToSrcBytes:   5801,
+		}
+
+		if got := b.Tuples()[0]; !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected tuple.\nwant: %+v\ngot:  %+v", want, got)
+		}
+	})
+
+	t.Run("ReturnsValidTuplesFromPartialBlocks", func(t *testing.T) {
+		partial := strings.Replace(testBlock, "1660039351,38.88.252.187,10.0.0.4,59246,22,T,I,A,C,1,2,3,4", "1660039351,38.88.252.187,10.0.0.4,592", 1)
+		pb, err := ParseBlock([]byte(partial))
+
+		var blockErr *BlockError
+		if !errors.As(err, &blockErr) || len(blockErr.TupleErrors) != 1 {
+			t.Fatalf("expected block error with one tuple error, got %v", err)
+		}
+
+		if n := len(pb.Tuples()); n != 2 {
+			t.Errorf("unexpected number of tuples. want: 2, got: %v", n)
+		}
+	})
+
+	t.Run("BeginningFlowsHaveNoTraffic", func(t *testing.T) {
+		tuple := b.Tuples()[1]
+
+		if tuple.HasTraffic() || tuple.State != StateBegin || tuple.Protocol != ProtocolUdp || tuple.Decision != DecisionDeny {
+			t.Errorf("unexpected tuple: %+v", tuple)
+		}
+	})
+}
+
+func TestParseFlowTuple(t *testing.T) {
+	t.Run("ParsesVersion1Tuples", func(t *testing.T) {
+		tuple, err := ParseFlowTuple("1660039344,fd00::1,fd00::2,50276,443,T,I,D", 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tuple.SourceAddress != netip.MustParseAddr("fd00::1") || tuple.Direction != DirectionInbound || tuple.HasTraffic() {
+			t.Errorf("unexpected tuple: %+v", tuple)
+		}
+	})
+
+	t.Run("ReturnsErrorsForInvalidValues", func(t *testing.T) {
+		for _, tuple := range []string{
+			"abc,10.0.0.4,10.0.0.5,50276,443,T,I,D",
+			"1660039344,10.0.0,10.0.0.5,50276,443,T,I,D",
+			"1660039344,10.0.0.4,10.0.0.5,70000,443,T,I,D",
+			"1660039344,10.0.0.4,10.0.0.5,50276,443,X,I,D",
+			"1660039344,10.0.0.4,10.0.0.5,50276,443,T,I,Q",
+			"1660039344,10.0.0.4,10.0.0.5,50276,443,T,I,B",
+		} {
+			_, err := ParseFlowTuple(tuple, 1)
+
+			var tupleErr *TupleError
+			if !errors.As(err, &tupleErr) || !errors.Is(err, ErrInvalidField) {
+				t.Errorf("expected invalid field error parsing tuple %v, got %v", tuple, err)
+			}
+		}
+	})
+
+	t.Run("ValidatesFieldCountForVersion", func(t *testing.T) {
+		tests := []struct {
+			tuple   string
+			version int
+		}{
+			{"1660039344,10.0.0.4,10.0.0.5,50276,443", 1},
+			{"1660039344,10.0.0.4,10.0.0.5,50276,443,T,I,D,B,,,,", 1},
+			{"1660039344,10.0.0.4,10.0.0.5,50276,443,T,I,D", 2},
+			{"1660039344,10.0.0.4,10.0.0.5,50276,443,T,I,D,C,12,3769", 2},
+			{"", 2},
+		}
+
+		for _, tt := range tests {
+			if _, err := ParseFlowTuple(tt.tuple, tt.version); !errors.Is(err, ErrFieldCount) {
+				t.Errorf("expected field count error parsing v%v tuple %v, got %v", tt.version, tt.tuple, err)
+			}
+		}
+	})
+
+	t.Run("ValidatesVersion2State", func(t *testing.T) {
+		if _, err := ParseFlowTuple("1660039344,10.0.0.4,10.0.0.5,50276,443,T,I,D,X,,,,", 2); !errors.Is(err, ErrInvalidField) {
+			t.Errorf("expected invalid field error, got %v", err)
+		}
+	})
+}
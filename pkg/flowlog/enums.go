@@ -0,0 +1,144 @@
+package flowlog
+
+import "fmt"
+
+type Protocol int
+
+const (
+	ProtocolUnknown Protocol = iota
+	ProtocolTcp
+	ProtocolUdp
+)
+
+func parseProtocol(p string) (Protocol, error) {
+	switch p {
+	case "T":
+		return ProtocolTcp, nil
+	case "U":
+		return ProtocolUdp, nil
+	default:
+		return ProtocolUnknown, fmt.Errorf("unknown protocol '%v'", p)
+	}
+}
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolTcp:
+		return "tcp"
+	case ProtocolUdp:
+		return "udp"
+	default:
+		return ""
+	}
+}
+
+func (p Protocol) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+type Direction int
+
+const (
+	DirectionUnknown Direction = iota
+	DirectionInbound
+	DirectionOutbound
+)
+
+func parseDirection(dir string) (Direction, error) {
+	switch dir {
+	case "I":
+		return DirectionInbound, nil
+	case "O":
+		return DirectionOutbound, nil
+	default:
+		return DirectionUnknown, fmt.Errorf("unknown direction '%v'", dir)
+	}
+}
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "in"
+	case DirectionOutbound:
+		return "out"
+	default:
+		return ""
+	}
+}
+
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+type Decision int
+
+const (
+	DecisionUnknown Decision = iota
+	DecisionAllow
+	DecisionDeny
+)
+
+func parseDecision(dec string) (Decision, error) {
+	switch dec {
+	case "A":
+		return DecisionAllow, nil
+	case "D":
+		return DecisionDeny, nil
+	default:
+		return DecisionUnknown, fmt.Errorf("unknown decision '%v'", dec)
+	}
+}
+
+func (d Decision) String() string {
+	switch d {
+	case DecisionAllow:
+		return "allow"
+	case DecisionDeny:
+		return "deny"
+	default:
+		return ""
+	}
+}
+
+func (d Decision) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+type State int
+
+const (
+	StateUnknown State = iota
+	StateBegin
+	StateContinuing
+	StateEnd
+)
+
+func parseState(state string) (State, error) {
+	switch state {
+	case "B":
+		return StateBegin, nil
+	case "C":
+		return StateContinuing, nil
+	case "E":
+		return StateEnd, nil
+	default:
+		return StateUnknown, fmt.Errorf("unknown state '%v'", state)
+	}
+}
+
+func (s State) String() string {
+	switch s {
+	case StateBegin:
+		return "begin"
+	case StateContinuing:
+		return "continuing"
+	case StateEnd:
+		return "end"
+	default:
+		return ""
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
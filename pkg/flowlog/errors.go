@@ -0,0 +1,45 @@
+package flowlog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrFieldCount   error = errors.New("unexpected number of fields")
+	ErrInvalidField error = errors.New("invalid field value")
+)
+
+// TupleError describes a flow tuple that could not be parsed.
+type TupleError struct {
+	Tuple   string
+	Version int
+	Field   string
+	Err     error
+}
+
+func (e *TupleError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("malformed v%v flow tuple '%v': %v", e.Version, e.Tuple, e.Err)
+	}
+	return fmt.Sprintf("malformed v%v flow tuple '%v': %v: %v", e.Version, e.Tuple, e.Field, e.Err)
+}
+
+func (e *TupleError) Unwrap() error {
+	return e.Err
+}
+
+// BlockError is returned by ParseBlock when some of the tuples in a block could not be parsed, each
+// of its TupleErrors is a *TupleError.
+type BlockError struct {
+	TupleErrors []error
+}
+
+func (e *BlockError) Error() string {
+	msgs := make([]string, 0, len(e.TupleErrors))
+	for _, te := range e.TupleErrors {
+		msgs = append(msgs, te.Error())
+	}
+	return fmt.Sprintf("%v malformed flow tuple(s) in block: %v", len(e.TupleErrors), strings.Join(msgs, "; "))
+}
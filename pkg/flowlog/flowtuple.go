@@ -0,0 +1,129 @@
+package flowlog
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type FlowTuple struct {
+	Time             time.Time
+	Version          int
+	ResourceId       string
+	Mac              string
+	Rule             string
+	SourceAddress    netip.Addr
+	SourcePort       uint16
+	DestAddress      netip.Addr
+	DestPort         uint16
+	Protocol         Protocol
+	Direction        Direction
+	Decision         Decision
+	State            State
+	SrcToDestPackets uint64
+	SrcToDestBytes   uint64
+	DestToSrcPackets uint64
+	DestToSrcBytes   uint64
+}
+
+// HasTraffic reports whether the tuple carries packet and byte counts.  These are only recorded by
+// version 2 flow logs and are left empty when a flow begins.
+func (t *FlowTuple) HasTraffic() bool {
+	return t.Version >= 2 && (t.State == StateContinuing || t.State == StateEnd)
+}
+
+// NsgName returns the name of the NSG that logged the tuple, as it appears in the upper cased resource
+// ID of the record it came from.
+func (t *FlowTuple) NsgName() string {
+	id := strings.TrimSuffix(t.ResourceId, "/")
+	return id[strings.LastIndex(id, "/")+1:]
+}
+
+// ParseFlowTuple parses a single comma separated flow tuple as written by the given flow log version.
+// Version 1 tuples have 8 fields and version 2 tuples have 13, errors are returned as a *TupleError.
+func ParseFlowTuple(tuple string, version int) (FlowTuple, error) {
+	fields := strings.Split(tuple, ",")
+
+	if want := fieldCount(version); len(fields) != want {
+		return FlowTuple{}, &TupleError{tuple, version, "", fmt.Errorf("%w: want %v, got %v", ErrFieldCount, want, len(fields))}
+	}
+
+	t := FlowTuple{Version: version}
+	var err error
+
+	invalid := func(field string, err error) error {
+		return &TupleError{tuple, version, field, fmt.Errorf("%w: %v", ErrInvalidField, err)}
+	}
+
+	if t.Time, err = parseUnixTime(fields[0]); err != nil {
+		return FlowTuple{}, invalid("time", err)
+	}
+	if t.SourceAddress, err = netip.ParseAddr(fields[1]); err != nil {
+		return FlowTuple{}, invalid("source address", err)
+	}
+	if t.DestAddress, err = netip.ParseAddr(fields[2]); err != nil {
+		return FlowTuple{}, invalid("destination address", err)
+	}
+	if t.SourcePort, err = parsePort(fields[3]); err != nil {
+		return FlowTuple{}, invalid("source port", err)
+	}
+	if t.DestPort, err = parsePort(fields[4]); err != nil {
+		return FlowTuple{}, invalid("destination port", err)
+	}
+	if t.Protocol, err = parseProtocol(fields[5]); err != nil {
+		return FlowTuple{}, invalid("protocol", err)
+	}
+	if t.Direction, err = parseDirection(fields[6]); err != nil {
+		return FlowTuple{}, invalid("direction", err)
+	}
+	if t.Decision, err = parseDecision(fields[7]); err != nil {
+		return FlowTuple{}, invalid("decision", err)
+	}
+
+	if version < 2 {
+		return t, nil
+	}
+
+	if t.State, err = parseState(fields[8]); err != nil {
+		return FlowTuple{}, invalid("state", err)
+	}
+
+	counts := []*uint64{&t.SrcToDestPackets, &t.SrcToDestBytes, &t.DestToSrcPackets, &t.DestToSrcBytes}
+
+	for i, c := range counts {
+		if *c, err = parseCount(fields[9+i]); err != nil {
+			return FlowTuple{}, invalid("traffic count", err)
+		}
+	}
+
+	return t, nil
+}
+
+func fieldCount(version int) int {
+	if version < 2 {
+		return 8
+	}
+	return 13
+}
+
+func parseUnixTime(unixTime string) (time.Time, error) {
+	t, err := strconv.ParseInt(unixTime, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(t, 0).UTC(), nil
+}
+
+func parsePort(port string) (uint16, error) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	return uint16(p), err
+}
+
+func parseCount(count string) (uint64, error) {
+	if count == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(count, 10, 64)
+}
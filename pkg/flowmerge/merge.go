@@ -0,0 +1,171 @@
+package flowmerge
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+// maxBatchSize is the largest number of tuples Merge sends in a single batch.
+var maxBatchSize = 1000
+
+// Input is a time ordered stream of tuple batches.
+type Input struct {
+	// Start is the earliest time of any tuple in the stream, Merge doesn't wait for a stream's first
+	// batch until every tuple before Start has been sent.
+	Start  time.Time
+	Tuples <-chan []flowlog.FlowTuple
+}
+
+// Merge reads every input until it is closed and sends the tuples to out in time order, closing out
+// when done.  Tuples are sent as soon as no input can still produce an earlier one.  Each input must
+// be ordered; a tuple that arrives earlier than one already sent is sent straight away.  If ctx is
+// cancelled Merge returns without closing out.
+func Merge(ctx context.Context, inputs []Input, out chan<- []flowlog.FlowTuple) {
+	m := merger{ctx: ctx, out: out}
+	m.pending = append(m.pending, inputs...)
+	sort.SliceStable(m.pending, func(i, j int) bool {
+		return m.pending[i].Start.Before(m.pending[j].Start)
+	})
+
+	for {
+		m.activate()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if m.active.Len() == 0 {
+			break
+		}
+
+		s := m.active[0]
+		m.batch = append(m.batch, s.next())
+
+		if s.empty() {
+			m.advance(s)
+		} else {
+			heap.Fix(&m.active, 0)
+		}
+
+		if len(m.batch) >= maxBatchSize {
+			m.send()
+		}
+	}
+
+	m.send()
+	if ctx.Err() != nil {
+		return
+	}
+	close(out)
+}
+
+type merger struct {
+	ctx     context.Context
+	out     chan<- []flowlog.FlowTuple
+	pending []Input
+	active  streamHeap
+	batch   []flowlog.FlowTuple
+}
+
+// activate starts reading every pending input that could hold a tuple earlier than the next one to be sent.
+func (m *merger) activate() {
+	for len(m.pending) > 0 && (m.active.Len() == 0 || !m.active[0].head().Time.Before(m.pending[0].Start)) {
+		s := &stream{tuples: m.pending[0].Tuples}
+		m.pending = m.pending[1:]
+
+		if m.receive(s) {
+			heap.Push(&m.active, s)
+		}
+	}
+}
+
+// advance refills a stream that has run out of buffered tuples, removing it once its input is closed.
+func (m *merger) advance(s *stream) {
+	if m.receive(s) {
+		heap.Fix(&m.active, 0)
+	} else {
+		heap.Pop(&m.active)
+	}
+}
+
+// receive waits for the next non-empty batch from a stream's input, returning false once the input is closed
+// or the merge is cancelled.
+func (m *merger) receive(s *stream) bool {
+	for {
+		var batch []flowlog.FlowTuple
+		var ok bool
+
+		select {
+		case batch, ok = <-s.tuples:
+		default:
+			// don't hold on to tuples that are ready to be sent while waiting for more
+			m.send()
+			select {
+			case batch, ok = <-s.tuples:
+			case <-m.ctx.Done():
+				return false
+			}
+		}
+
+		if !ok {
+			return false
+		}
+
+		if len(batch) > 0 {
+			s.batch = batch
+			return true
+		}
+	}
+}
+
+func (m *merger) send() {
+	if len(m.batch) > 0 {
+		select {
+		case m.out <- m.batch:
+		case <-m.ctx.Done():
+		}
+		m.batch = nil
+	}
+}
+
+type stream struct {
+	tuples <-chan []flowlog.FlowTuple
+	batch  []flowlog.FlowTuple
+}
+
+func (s *stream) head() flowlog.FlowTuple {
+	return s.batch[0]
+}
+
+func (s *stream) next() flowlog.FlowTuple {
+	t := s.batch[0]
+	s.batch = s.batch[1:]
+	return t
+}
+
+func (s *stream) empty() bool {
+	return len(s.batch) == 0
+}
+
+type streamHeap []*stream
+
+func (h streamHeap) Len() int { return len(h) }
+
+func (h streamHeap) Less(i, j int) bool { return h[i].head().Time.Before(h[j].head().Time) }
+
+func (h streamHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *streamHeap) Push(x interface{}) {
+	*h = append(*h, x.(*stream))
+}
+
+func (h *streamHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	s := old[n-1]
+	*h = old[:n-1]
+	return s
+}
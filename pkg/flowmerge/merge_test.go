@@ -0,0 +1,162 @@
+package flowmerge
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+var mergeTestStart = time.Date(2022, 8, 9, 10, 0, 0, 0, time.UTC)
+
+func tuplesAt(minutes ...int) []flowlog.FlowTuple {
+	tuples := make([]flowlog.FlowTuple, 0, len(minutes))
+	for _, m := range minutes {
+		tuples = append(tuples, flowlog.FlowTuple{Time: mergeTestStart.Add(time.Duration(m) * time.Minute)})
+	}
+	return tuples
+}
+
+func sendBatches(batches ...[]flowlog.FlowTuple) <-chan []flowlog.FlowTuple {
+	ch := make(chan []flowlog.FlowTuple)
+	go func() {
+		for _, b := range batches {
+			ch <- b
+		}
+		close(ch)
+	}()
+	return ch
+}
+
+func collect(t *testing.T, out <-chan []flowlog.FlowTuple) (tuples []flowlog.FlowTuple) {
+	t.Helper()
+	for {
+		select {
+		case batch, ok := <-out:
+			if !ok {
+				return
+			}
+			tuples = append(tuples, batch...)
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for merge")
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Run("MergesInputsInTimeOrder", func(t *testing.T) {
+		inputs := []Input{
+			{mergeTestStart, sendBatches(tuplesAt(0, 4, 8), tuplesAt(), tuplesAt(12, 20))},
+			{mergeTestStart, sendBatches(tuplesAt(1, 2), tuplesAt(9, 30))},
+			{mergeTestStart, sendBatches()},
+			{mergeTestStart.Add(time.Minute * 3), sendBatches(tuplesAt(3, 3, 15))},
+		}
+
+		out := make(chan []flowlog.FlowTuple)
+		go Merge(context.Background(), inputs, out)
+		got := collect(t, out)
+
+		if len(got) != 12 {
+			t.Fatalf("unexpected number of tuples. want: 12, got: %v", len(got))
+		}
+
+		if !sort.SliceIsSorted(got, func(i, j int) bool { return got[i].Time.Before(got[j].Time) }) {
+			t.Errorf("expected tuples to be in time order, got %v", got)
+		}
+	})
+
+	t.Run("DoesNotWaitForLaterInputs", func(t *testing.T) {
+		later := make(chan []flowlog.FlowTuple)
+		inputs := []Input{
+			{mergeTestStart.Add(time.Hour), later},
+			{mergeTestStart, sendBatches(tuplesAt(0, 10, 20))},
+		}
+
+		out := make(chan []flowlog.FlowTuple)
+		go Merge(context.Background(), inputs, out)
+
+		select {
+		case batch := <-out:
+			if len(batch) != 3 {
+				t.Errorf("expected earlier tuples to be sent first, got %v", batch)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("merge waited for an input that starts later")
+		}
+
+		later <- tuplesAt(60)
+		close(later)
+
+		if got := collect(t, out); len(got) != 1 {
+			t.Errorf("expected tuples from later input, got %v", got)
+		}
+	})
+
+	t.Run("SendsTuplesBeforeBlockingOnInputs", func(t *testing.T) {
+		slow := make(chan []flowlog.FlowTuple)
+		inputs := []Input{
+			{mergeTestStart, sendBatches(tuplesAt(0, 1), tuplesAt(10))},
+			{mergeTestStart, slow},
+		}
+
+		out := make(chan []flowlog.FlowTuple)
+		go Merge(context.Background(), inputs, out)
+
+		slow <- tuplesAt(5)
+
+		// 0, 1 and 5 can be sent while the merge waits to find out whether the slow input has anything before 10
+		var received []flowlog.FlowTuple
+		for len(received) < 3 {
+			select {
+			case batch := <-out:
+				received = append(received, batch...)
+			case <-time.After(time.Second * 5):
+				t.Fatalf("merge held on to tuples while waiting for input, received %v", received)
+			}
+		}
+
+		if len(received) != 3 {
+			t.Errorf("unexpected tuples sent before input was closed: %v", received)
+		}
+
+		close(slow)
+
+		if got := collect(t, out); len(got) != 1 {
+			t.Errorf("expected the remaining tuple once the input was closed, got %v", got)
+		}
+	})
+
+	t.Run("ReturnsWhenCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// neither input is ever closed and nothing reads out
+		blocked := make(chan []flowlog.FlowTuple)
+		inputs := []Input{
+			{mergeTestStart, sendBatches(tuplesAt(0, 1))},
+			{mergeTestStart, blocked},
+		}
+
+		out := make(chan []flowlog.FlowTuple)
+		done := make(chan struct{})
+		go func() {
+			Merge(ctx, inputs, out)
+			close(done)
+		}()
+
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second * 5):
+			t.Fatal("merge didn't return once cancelled")
+		}
+
+		select {
+		case _, ok := <-out:
+			t.Errorf("expected out to be left open, received: %v", ok)
+		default:
+		}
+	})
+}
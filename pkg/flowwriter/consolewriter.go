@@ -6,70 +6,118 @@ import (
 	"time"
 
 	"github.com/olekukonko/tablewriter"
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
 )
 
+// ConsoleWriter writes tuples as a table whose header is written before the first row.  Later rows are
+// padded to line up with those above them, and the header is only repeated if a value is too wide for
+// its column.
 type ConsoleWriter struct {
-	w          io.Writer
-	table      *tablewriter.Table
-	flowTuples []flowTuple
-	filter     filter
+	w       *errWriter
+	buffer  tupleBuffer
+	filters FilterChain
+	// widths holds the widest value seen in each column
+	widths        []int
+	headerWritten bool
 }
 
 func NewConsoleWriter(w io.Writer) *ConsoleWriter {
 	cw := ConsoleWriter{
-		w: w,
+		w:      &errWriter{w: w},
+		widths: make([]int, len(columnHeaders)),
 	}
-	cw.initTableWriter()
+	cw.updateWidths(columnHeaders)
 	return &cw
 }
 
-func (c *ConsoleWriter) AddFilter(f filter) {
-	c.filter = f
+func (c *ConsoleWriter) AddFilter(f Filter) {
+	c.filters.Add(f)
 }
 
-func (cw *ConsoleWriter) initTableWriter() {
-	cw.table = tablewriter.NewWriter(cw.w)
-	cw.table.SetColumnSeparator("")
-	cw.table.SetRowSeparator("")
-	cw.table.SetBorder(false)
-	cw.table.SetTablePadding("\t")
-	cw.table.SetHeaderLine(false)
-	cw.table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	cw.table.SetAutoFormatHeaders(false)
-	cw.table.SetHeader([]string{"time", "rule", "src_addr", "src_port", "dst_addr", "dst_port", "direction", "decision", "state", "src_to_dst_bytes", "dst_to_src_bytes"})
-	cw.table.Append([]string{"", "", "", "", "", "", "", "", "", "", ""})
+func (c *ConsoleWriter) SetSortWindow(d time.Duration) {
+	c.buffer.window = d
+}
+
+func (cw *ConsoleWriter) newTableWriter() *tablewriter.Table {
+	table := tablewriter.NewWriter(cw.w)
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetBorder(false)
+	table.SetTablePadding("\t")
+	table.SetHeaderLine(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAutoFormatHeaders(false)
+
+	for i, w := range cw.widths {
+		table.SetColMinWidth(i, w)
+	}
+
+	return table
+}
+
+// updateWidths widens the columns to fit the values, returning true if any had to be widened.
+func (cw *ConsoleWriter) updateWidths(columns []string) bool {
+	widened := false
+	for i, c := range columns {
+		if w := tablewriter.DisplayWidth(c); w > cw.widths[i] {
+			cw.widths[i] = w
+			widened = true
+		}
+	}
+	return widened
 }
 
 func (cw *ConsoleWriter) WriteFlowBlock(data []byte) error {
-	fb, err := newFlowLogBlock(data)
+	fb, err := flowlog.ParseBlock(data)
 	if err != nil {
 		return fmt.Errorf("unable to decode flow log block: %w \n%v", err, string(data))
 	}
 
-	cw.saveFlowBlock(fb)
-	return nil
+	return cw.WriteFlowTuples(fb.Tuples())
 }
 
-func (cw *ConsoleWriter) saveFlowBlock(fb *flowLogBlock) {
-	tuples := getFlowTuples(fb)
+func (cw *ConsoleWriter) WriteFlowTuples(tuples []flowlog.FlowTuple) error {
+	cw.buffer.add(cw.filters.apply(tuples))
 
+	if ready := cw.buffer.ready(); len(ready) > 0 {
+		cw.render(ready)
+	}
+
+	return cw.w.err
+}
+
+func (cw *ConsoleWriter) Flush() error {
+	cw.render(cw.buffer.drain())
+	if cw.w.err != nil {
+		return cw.w.err
+	}
+	return flushWriter(cw.w.w)
+}
+
+// render appends rows for the tuples to the table, writing the header first if it hasn't been written
+// or the columns no longer line up with it.
+func (cw *ConsoleWriter) render(tuples []flowlog.FlowTuple) {
+	if len(tuples) == 0 {
+		return
+	}
+
+	rows := make([][]string, 0, len(tuples))
 	for _, t := range tuples {
-		if cw.filter == nil || cw.filter.Print(t) {
-			cw.flowTuples = append(cw.flowTuples, t)
+		columns := tupleColumns(t)
+		if cw.updateWidths(columns) {
+			cw.headerWritten = false
 		}
+		rows = append(rows, columns)
 	}
-}
 
-func (cw *ConsoleWriter) Flush() {
-	sortFlowTuples(cw.flowTuples)
+	table := cw.newTableWriter()
 
-	for _, t := range cw.flowTuples {
-		cw.table.Append([]string{t.Time.Format(time.StampMilli), t.Rule, t.SourceAddress, t.SourcePort,
-			t.DestAddress, t.DestPort, t.Direction, t.Decision, t.State, t.SrcToDestBytes, t.DestToSrcBytes})
+	if !cw.headerWritten {
+		table.SetHeader(columnHeaders)
+		table.Append(make([]string, len(columnHeaders)))
+		cw.headerWritten = true
 	}
 
-	fmt.Print("\n")
-	cw.table.Render()
-	fmt.Print("\n")
-	cw.initTableWriter()
+	table.AppendBulk(rows)
+	table.Render()
 }
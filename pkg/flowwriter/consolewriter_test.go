@@ -27,7 +27,7 @@ var consoleTestFlows string = `
             "mac": "000D3AD488D1",
             "flowTuples": [
               "1660039344,10.0.0.4,51.104.229.52,50276,443,T,O,A,E,14,2839,14,5801",
-              "1660039344,10.0.0.4,51.105.74.153,47382,443,T,O,B,B,,,,",
+              "1660039344,10.0.0.4,51.105.74.153,47382,443,T,O,D,B,,,,",
               "1660039350,10.0.0.4,51.105.74.153,47382,443,T,O,A,C,12,3769,10,5061"
             ]
           }
@@ -64,14 +64,14 @@ var consoleTestFlows string = `
 `
 
 var wantedConsoleLines = [][]string{
-	{"Aug", "9", "10:02:24.000", "DefaultRule_AllowInternetOutBound", "10.0.0.4", "50276", "51.104.229.52", "443", "out", "allow", "end", "2839", "5801"},
-	{"Aug", "9", "10:02:24.000", "DefaultRule_AllowInternetOutBound", "10.0.0.4", "47382", "51.105.74.153", "443", "out", "deny", "begin"},
-	{"Aug", "9", "10:02:30.000", "DefaultRule_AllowInternetOutBound", "10.0.0.4", "47382", "51.105.74.153", "443", "out", "allow", "continuing", "3769", "5061"},
-	{"Aug", "9", "10:02:36.000", "DefaultRule_DenyAllInBound", "117.88.229.255", "50996", "10.0.0.4", "23", "in", "deny", "begin"},
-	{"Aug", "9", "10:02:41.000", "DefaultRule_DenyAllInBound", "167.99.14.84", "39984", "10.0.0.4", "8080", "in", "deny", "begin"},
-	{"Aug", "9", "10:02:49.000", "DefaultRule_DenyAllInBound", "176.63.187.19", "46852", "10.0.0.4", "23", "in", "deny", "begin"},
-	{"Aug", "9", "10:02:31.000", "UserRule_ssh", "38.88.252.187", "59246", "10.0.0.4", "22", "in", "allow", "begin"},
-	{"Aug", "9", "10:02:38.000", "UserRule_ssh", "61.177.173.21", "56496", "10.0.0.4", "22", "in", "allow", "begin"},
+	{"Aug", "9", "10:02:24.000", "NSG-VIEW", "DefaultRule_AllowInternetOutBound", "10.0.0.4", "50276", "51.104.229.52", "443", "out", "allow", "end", "2839", "5801"},
+	{"Aug", "9", "10:02:24.000", "NSG-VIEW", "DefaultRule_AllowInternetOutBound", "10.0.0.4", "47382", "51.105.74.153", "443", "out", "deny", "begin"},
+	{"Aug", "9", "10:02:30.000", "NSG-VIEW", "DefaultRule_AllowInternetOutBound", "10.0.0.4", "47382", "51.105.74.153", "443", "out", "allow", "continuing", "3769", "5061"},
+	{"Aug", "9", "10:02:36.000", "NSG-VIEW", "DefaultRule_DenyAllInBound", "117.88.229.255", "50996", "10.0.0.4", "23", "in", "deny", "begin"},
+	{"Aug", "9", "10:02:41.000", "NSG-VIEW", "DefaultRule_DenyAllInBound", "167.99.14.84", "39984", "10.0.0.4", "8080", "in", "deny", "begin"},
+	{"Aug", "9", "10:02:49.000", "NSG-VIEW", "DefaultRule_DenyAllInBound", "176.63.187.19", "46852", "10.0.0.4", "23", "in", "deny", "begin"},
+	{"Aug", "9", "10:02:31.000", "NSG-VIEW", "UserRule_ssh", "38.88.252.187", "59246", "10.0.0.4", "22", "in", "allow", "begin"},
+	{"Aug", "9", "10:02:38.000", "NSG-VIEW", "UserRule_ssh", "61.177.173.21", "56496", "10.0.0.4", "22", "in", "allow", "begin"},
 }
 
 func TestConsoleWriter(t *testing.T) {
@@ -88,7 +88,7 @@ func TestConsoleWriter(t *testing.T) {
 	t.Run("TestHeader", func(t *testing.T) {
 		headerLine := strings.Split(buffer.String(), "\n")[0]
 		got := strings.Fields(headerLine)
-		want := []string{"time", "rule", "src_addr", "src_port", "dst_addr", "dst_port", "direction", "decision", "state", "src_to_dst_bytes", "dst_to_src_bytes"}
+		want := []string{"time", "nsg", "rule", "src_addr", "src_port", "dst_addr", "dst_port", "direction", "decision", "state", "src_to_dst_bytes", "dst_to_src_bytes"}
 
 		if len(got) != len(want) {
 			t.Errorf("unexpected number of items in header line.  want: %v, got %v", want, got)
@@ -124,6 +124,91 @@ func TestConsoleWriter(t *testing.T) {
 	})
 }
 
+func TestConsoleWriterBatches(t *testing.T) {
+	t.Run("NothingWrittenWithoutTuples", func(t *testing.T) {
+		var buffer bytes.Buffer
+		cw := NewConsoleWriter(&buffer)
+
+		if err := cw.Flush(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buffer.Len() != 0 {
+			t.Errorf("expected no output, got %q", buffer.String())
+		}
+	})
+
+	t.Run("HeaderWrittenOnceAndRowsLineUp", func(t *testing.T) {
+		var buffer bytes.Buffer
+		cw := NewConsoleWriter(&buffer)
+
+		for i := 0; i < 2; i++ {
+			if err := cw.WriteFlowBlock([]byte(consoleTestFlows)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := cw.Flush(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := cw.Flush(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+
+		if want := 2 + len(wantedConsoleLines)*2; len(lines) != want {
+			t.Fatalf("unexpected number of lines. want: %v, got: %v\n%v", want, len(lines), buffer.String())
+		}
+
+		if headers := strings.Count(buffer.String(), "src_to_dst_bytes"); headers != 1 {
+			t.Errorf("expected the header once, got it %v times", headers)
+		}
+
+		// the first batch's rows are repeated so the second batch's should be identical
+		first, second := lines[2:2+len(wantedConsoleLines)], lines[2+len(wantedConsoleLines):]
+		for i := range first {
+			if first[i] != second[i] {
+				t.Errorf("rows in later batch don't line up.\nwant: %q\ngot:  %q", first[i], second[i])
+			}
+		}
+	})
+
+	t.Run("HeaderRepeatedWhenColumnWidens", func(t *testing.T) {
+		var buffer bytes.Buffer
+		cw := NewConsoleWriter(&buffer)
+
+		if err := cw.WriteFlowBlock([]byte(consoleTestFlows)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cw.Flush()
+
+		// a longer rule name than any before needs a wider column
+		wider := strings.ReplaceAll(consoleTestFlows, "UserRule_ssh", "UserRule_ssh_from_the_management_network")
+		if err := cw.WriteFlowBlock([]byte(wider)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		cw.Flush()
+
+		if headers := strings.Count(buffer.String(), "src_to_dst_bytes"); headers != 2 {
+			t.Fatalf("expected the header twice, got it %v times", headers)
+		}
+
+		// every row's src_addr column starts under the header above it
+		var col int
+		for _, l := range strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n") {
+			if i := strings.Index(l, "src_addr"); i >= 0 {
+				col = i
+				continue
+			}
+			if strings.TrimSpace(l) == "" {
+				continue
+			}
+			if len(l) <= col || l[col-1] != ' ' || l[col] == ' ' {
+				t.Errorf("src_addr column doesn't start under its header in %q", l)
+			}
+		}
+	})
+}
+
 type SortConsoleLinesByTime [][]string
 
 func (s SortConsoleLinesByTime) Len() int { return len(s) }
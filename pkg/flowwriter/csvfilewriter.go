@@ -3,13 +3,16 @@ package flowwriter
 import (
 	"fmt"
 	"io"
+	"strings"
 	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
 )
 
 type CsvFileWriter struct {
-	w          io.Writer
-	flowTuples []flowTuple
-	filter     filter
+	w       io.Writer
+	buffer  tupleBuffer
+	filters FilterChain
 }
 
 func NewCsvFileWriter(w io.Writer) (*CsvFileWriter, error) {
@@ -20,12 +23,16 @@ func NewCsvFileWriter(w io.Writer) (*CsvFileWriter, error) {
 	return &c, err
 }
 
-func (c *CsvFileWriter) AddFilter(f filter) {
-	c.filter = f
+func (c *CsvFileWriter) AddFilter(f Filter) {
+	c.filters.Add(f)
+}
+
+func (c *CsvFileWriter) SetSortWindow(d time.Duration) {
+	c.buffer.window = d
 }
 
 func (c *CsvFileWriter) writeHeaders() error {
-	headers := "time,rule,src_addr,src_port,dst_addr,dst_port,direction,decision,state,src_to_dst_bytes,dst_to_src_bytes"
+	headers := strings.Join(columnHeaders, ",")
 
 	err := c.writeLine(headers)
 	if err != nil {
@@ -42,31 +49,40 @@ func (c *CsvFileWriter) writeLine(line string) error {
 }
 
 func (c *CsvFileWriter) WriteFlowBlock(data []byte) error {
-	fb, err := newFlowLogBlock(data)
+	fb, err := flowlog.ParseBlock(data)
 	if err != nil {
 		return fmt.Errorf("unable to decode flow log block: %w \n%v", err, string(data))
 	}
 
-	c.saveFlowBlock(fb)
-	return nil
+	return c.WriteFlowTuples(fb.Tuples())
 }
 
-func (c *CsvFileWriter) saveFlowBlock(fb *flowLogBlock) {
-	tuples := getFlowTuples(fb)
+func (c *CsvFileWriter) WriteFlowTuples(tuples []flowlog.FlowTuple) error {
+	c.buffer.add(c.filters.apply(tuples))
+	return c.writeTuples(c.buffer.ready())
+}
 
-	for _, t := range tuples {
-		if c.filter == nil || c.filter.Print(t) {
-			c.flowTuples = append(c.flowTuples, t)
-		}
+func (c *CsvFileWriter) Flush() error {
+	if err := c.writeTuples(c.buffer.drain()); err != nil {
+		return err
 	}
+	return flushWriter(c.w)
 }
 
-func (c *CsvFileWriter) Flush() {
-	sortFlowTuples(c.flowTuples)
+// Close closes the underlying writer if it is an io.Closer.  Call Flush first to write buffered tuples.
+func (c *CsvFileWriter) Close() error {
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
 
-	for _, t := range c.flowTuples {
-		line := fmt.Sprintf("%v,%v,%v,%v,%v,%v,%v,%v,%v,%v,%v", t.Time.Format(time.StampMilli), t.Rule, t.SourceAddress, t.SourcePort,
-			t.DestAddress, t.DestPort, t.Direction, t.Decision, t.State, t.SrcToDestBytes, t.DestToSrcBytes)
-		c.writeLine(line)
+func (c *CsvFileWriter) writeTuples(tuples []flowlog.FlowTuple) error {
+	for _, t := range tuples {
+		line := strings.Join(tupleColumns(t), ",")
+		if err := c.writeLine(line); err != nil {
+			return fmt.Errorf("failed to write csv line: %w", err)
+		}
 	}
+	return nil
 }
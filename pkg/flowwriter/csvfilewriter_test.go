@@ -2,10 +2,14 @@ package flowwriter
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"sort"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
 )
 
 var csvWriterTestFlows string = `
@@ -26,7 +30,7 @@ var csvWriterTestFlows string = `
             "mac": "000D3AD488D1",
             "flowTuples": [
               "1660039344,10.0.0.4,51.104.229.52,50276,443,T,O,A,E,14,2839,14,5801",
-              "1660039344,10.0.0.4,51.105.74.153,47382,443,T,O,B,B,,,,",
+              "1660039344,10.0.0.4,51.105.74.153,47382,443,T,O,D,B,,,,",
               "1660039350,10.0.0.4,51.105.74.153,47382,443,T,O,A,C,12,3769,10,5061"
             ]
           }
@@ -63,14 +67,14 @@ var csvWriterTestFlows string = `
 `
 
 var wantedCsvFileLines = []string{
-	"Aug  9 10:02:24.000,DefaultRule_AllowInternetOutBound,10.0.0.4,50276,51.104.229.52,443,out,allow,end,2839,5801",
-	"Aug  9 10:02:24.000,DefaultRule_AllowInternetOutBound,10.0.0.4,47382,51.105.74.153,443,out,deny,begin,,",
-	"Aug  9 10:02:30.000,DefaultRule_AllowInternetOutBound,10.0.0.4,47382,51.105.74.153,443,out,allow,continuing,3769,5061",
-	"Aug  9 10:02:36.000,DefaultRule_DenyAllInBound,117.88.229.255,50996,10.0.0.4,23,in,deny,begin,,",
-	"Aug  9 10:02:41.000,DefaultRule_DenyAllInBound,167.99.14.84,39984,10.0.0.4,8080,in,deny,begin,,",
-	"Aug  9 10:02:49.000,DefaultRule_DenyAllInBound,176.63.187.19,46852,10.0.0.4,23,in,deny,begin,,",
-	"Aug  9 10:02:31.000,UserRule_ssh,38.88.252.187,59246,10.0.0.4,22,in,allow,begin,,",
-	"Aug  9 10:02:38.000,UserRule_ssh,61.177.173.21,56496,10.0.0.4,22,in,allow,begin,,",
+	"Aug  9 10:02:24.000,NSG-VIEW,DefaultRule_AllowInternetOutBound,10.0.0.4,50276,51.104.229.52,443,out,allow,end,2839,5801",
+	"Aug  9 10:02:24.000,NSG-VIEW,DefaultRule_AllowInternetOutBound,10.0.0.4,47382,51.105.74.153,443,out,deny,begin,,",
+	"Aug  9 10:02:30.000,NSG-VIEW,DefaultRule_AllowInternetOutBound,10.0.0.4,47382,51.105.74.153,443,out,allow,continuing,3769,5061",
+	"Aug  9 10:02:36.000,NSG-VIEW,DefaultRule_DenyAllInBound,117.88.229.255,50996,10.0.0.4,23,in,deny,begin,,",
+	"Aug  9 10:02:41.000,NSG-VIEW,DefaultRule_DenyAllInBound,167.99.14.84,39984,10.0.0.4,8080,in,deny,begin,,",
+	"Aug  9 10:02:49.000,NSG-VIEW,DefaultRule_DenyAllInBound,176.63.187.19,46852,10.0.0.4,23,in,deny,begin,,",
+	"Aug  9 10:02:31.000,NSG-VIEW,UserRule_ssh,38.88.252.187,59246,10.0.0.4,22,in,allow,begin,,",
+	"Aug  9 10:02:38.000,NSG-VIEW,UserRule_ssh,61.177.173.21,56496,10.0.0.4,22,in,allow,begin,,",
 }
 
 func TestCsvFileWriter(t *testing.T) {
@@ -90,7 +94,7 @@ func TestCsvFileWriter(t *testing.T) {
 	t.Run("TestCsvFileWriterWritesCorrectHeaders", func(t *testing.T) {
 		headerLine := strings.Split(buffer.String(), "\n")[0]
 		got := strings.Split(headerLine, ",")
-		want := []string{"time", "rule", "src_addr", "src_port", "dst_addr", "dst_port", "direction", "decision", "state", "src_to_dst_bytes", "dst_to_src_bytes"}
+		want := []string{"time", "nsg", "rule", "src_addr", "src_port", "dst_addr", "dst_port", "direction", "decision", "state", "src_to_dst_bytes", "dst_to_src_bytes"}
 
 		if len(got) != len(want) {
 			t.Errorf("unexpected number of headers.  want: %v, got: %v", want, got)
@@ -131,6 +135,57 @@ func TestCsvFileWriter(t *testing.T) {
 	})
 }
 
+func TestCsvFileWriterDoesNotRewriteFlushedTuples(t *testing.T) {
+	var buffer bytes.Buffer
+	csvWriter, err := NewCsvFileWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := csvWriter.WriteFlowBlock([]byte(csvWriterTestFlows)); err != nil {
+			t.Fatal(err)
+		}
+		csvWriter.Flush()
+	}
+
+	fileLines := strings.Split(strings.TrimSpace(buffer.String()), "\n")[1:]
+
+	if len(fileLines) != 2*len(wantedCsvFileLines) {
+		t.Errorf("unexpected number of file lines. want: %v, got: %v", 2*len(wantedCsvFileLines), len(fileLines))
+	}
+}
+
+func TestCsvFileWriterSortWindow(t *testing.T) {
+	var buffer bytes.Buffer
+	csvWriter, err := NewCsvFileWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csvWriter.SetSortWindow(10 * time.Second)
+
+	if err := csvWriter.WriteFlowBlock([]byte(csvWriterTestFlows)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the newest tuple is at 10:02:49 so only tuples before 10:02:39 can have been written
+	written := strings.Split(strings.TrimSpace(buffer.String()), "\n")[1:]
+	if len(written) != 6 {
+		t.Errorf("unexpected number of lines written before flush. want: 6, got: %v", len(written))
+	}
+
+	csvWriter.Flush()
+	allLines := strings.Split(strings.TrimSpace(buffer.String()), "\n")[1:]
+
+	if len(allLines) != len(wantedCsvFileLines) {
+		t.Errorf("unexpected number of file lines. want: %v, got: %v", len(wantedCsvFileLines), len(allLines))
+	}
+
+	if !sort.IsSorted(SortCsvFileLinesByTime(allLines)) {
+		t.Errorf("expected file lines to be in time order, got %v", allLines)
+	}
+}
+
 type SortCsvFileLinesByTime []string
 
 func (s SortCsvFileLinesByTime) Len() int { return len(s) }
@@ -153,3 +208,52 @@ func getCsvFileLineTime(line string) time.Time {
 
 	return time
 }
+
+// failingWriter fails every write once fail is set.
+type failingWriter struct {
+	bytes.Buffer
+	fail bool
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.fail {
+		return 0, errors.New("no space left on device")
+	}
+	return f.Buffer.Write(p)
+}
+
+func TestFlushReturnsWriteErrors(t *testing.T) {
+	newWriters := map[string]func(w io.Writer) FlowWriter{
+		"Csv": func(w io.Writer) FlowWriter {
+			c, err := NewCsvFileWriter(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return c
+		},
+		"JsonLines": func(w io.Writer) FlowWriter { return NewJsonLinesWriter(w) },
+		"Console":   func(w io.Writer) FlowWriter { return NewConsoleWriter(w) },
+	}
+
+	for name, newWriter := range newWriters {
+		t.Run(name, func(t *testing.T) {
+			out := &failingWriter{}
+			w := newWriter(out)
+			// hold every tuple back until the flush
+			w.SetSortWindow(time.Hour)
+
+			fb, err := flowlog.ParseBlock([]byte(csvWriterTestFlows))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteFlowTuples(fb.Tuples()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			out.fail = true
+			if err := w.Flush(); err == nil {
+				t.Error("expected flush to return the write error")
+			}
+		})
+	}
+}
@@ -1,12 +1,18 @@
 package flowwriter
 
-import "time"
+import (
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
 
 type TimeFilter struct {
 	Start time.Time
 	End   time.Time
 }
 
+// NewTimeFilter returns a filter printing tuples from start to end inclusive, a zero end leaves the range
+// open ended.
 func NewTimeFilter(start time.Time, end time.Time) *TimeFilter {
 	return &TimeFilter{
 		Start: start,
@@ -14,6 +20,87 @@ func NewTimeFilter(start time.Time, end time.Time) *TimeFilter {
 	}
 }
 
-func (f *TimeFilter) Print(t flowTuple) bool {
-	return (t.Time.Equal(f.Start) || t.Time.After(f.Start)) && (t.Time.Equal(f.End) || t.Time.Before(f.End))
+func (f *TimeFilter) Print(t flowlog.FlowTuple) bool {
+	return (t.Time.Equal(f.Start) || t.Time.After(f.Start)) && (f.End.IsZero() || t.Time.Equal(f.End) || t.Time.Before(f.End))
+}
+
+// FilterChain holds the filters added to a writer, a tuple is only printed if every filter in the chain matches.
+type FilterChain struct {
+	filters []Filter
+}
+
+func (c *FilterChain) Add(f Filter) {
+	c.filters = append(c.filters, f)
+}
+
+// apply returns the tuples that pass every filter in the chain.
+func (c *FilterChain) apply(tuples []flowlog.FlowTuple) []flowlog.FlowTuple {
+	var matched []flowlog.FlowTuple
+	for _, t := range tuples {
+		if c.Print(t) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+func (c *FilterChain) Print(t flowlog.FlowTuple) bool {
+	for _, f := range c.filters {
+		if !f.Print(t) {
+			return false
+		}
+	}
+	return true
+}
+
+type AndFilter struct {
+	Filters []Filter
+}
+
+func NewAndFilter(filters ...Filter) *AndFilter {
+	return &AndFilter{
+		Filters: filters,
+	}
+}
+
+func (f *AndFilter) Print(t flowlog.FlowTuple) bool {
+	for _, child := range f.Filters {
+		if !child.Print(t) {
+			return false
+		}
+	}
+	return true
+}
+
+type OrFilter struct {
+	Filters []Filter
+}
+
+func NewOrFilter(filters ...Filter) *OrFilter {
+	return &OrFilter{
+		Filters: filters,
+	}
+}
+
+func (f *OrFilter) Print(t flowlog.FlowTuple) bool {
+	for _, child := range f.Filters {
+		if child.Print(t) {
+			return true
+		}
+	}
+	return false
+}
+
+type NotFilter struct {
+	Filter Filter
+}
+
+func NewNotFilter(filter Filter) *NotFilter {
+	return &NotFilter{
+		Filter: filter,
+	}
+}
+
+func (f *NotFilter) Print(t flowlog.FlowTuple) bool {
+	return !f.Filter.Print(t)
 }
@@ -0,0 +1,78 @@
+package flowwriter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+type staticFilter bool
+
+func (f staticFilter) Print(t flowlog.FlowTuple) bool {
+	return bool(f)
+}
+
+func TestFilterChain(t *testing.T) {
+	tuple := flowlog.FlowTuple{Time: time.Date(2022, 8, 9, 10, 0, 0, 0, time.UTC)}
+
+	t.Run("EmptyChainPrintsEverything", func(t *testing.T) {
+		var chain FilterChain
+		if !chain.Print(tuple) {
+			t.Error("expected empty filter chain to print tuple")
+		}
+	})
+
+	t.Run("AddingAFilterDoesNotReplaceEarlierFilters", func(t *testing.T) {
+		var chain FilterChain
+		chain.Add(staticFilter(false))
+		chain.Add(staticFilter(true))
+
+		if chain.Print(tuple) {
+			t.Error("expected chain to reject tuple rejected by its first filter")
+		}
+	})
+
+	t.Run("TimeFilterWithoutEndIsOpenEnded", func(t *testing.T) {
+		var chain FilterChain
+		chain.Add(NewTimeFilter(tuple.Time.Add(-time.Minute), time.Time{}))
+
+		if !chain.Print(tuple) || chain.Print(flowlog.FlowTuple{Time: tuple.Time.Add(-time.Hour)}) {
+			t.Error("expected open ended time filter to print only tuples after its start")
+		}
+	})
+
+	t.Run("CombinesWithTimeFilter", func(t *testing.T) {
+		var chain FilterChain
+		chain.Add(NewTimeFilter(tuple.Time.Add(-time.Minute), tuple.Time.Add(time.Minute)))
+		chain.Add(staticFilter(true))
+
+		if !chain.Print(tuple) {
+			t.Error("expected chain to print tuple matched by all filters")
+		}
+	})
+}
+
+func TestCombinators(t *testing.T) {
+	tuple := flowlog.FlowTuple{}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{"AndAllTrue", NewAndFilter(staticFilter(true), staticFilter(true)), true},
+		{"AndOneFalse", NewAndFilter(staticFilter(true), staticFilter(false)), false},
+		{"OrOneTrue", NewOrFilter(staticFilter(false), staticFilter(true)), true},
+		{"OrAllFalse", NewOrFilter(staticFilter(false), staticFilter(false)), false},
+		{"Not", NewNotFilter(staticFilter(true)), false},
+		{"Nested", NewNotFilter(NewAndFilter(staticFilter(true), NewOrFilter(staticFilter(false)))), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Print(tuple); got != tt.want {
+				t.Errorf("unexpected result. want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
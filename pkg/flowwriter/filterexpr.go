@@ -0,0 +1,529 @@
+package flowwriter
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+// ParseFilter parses an expression such as 'src_addr in 10.0.0.0/8 and dst_port == 443' into a Filter.
+// Comparisons support ==, !=, <, <=, >, >=, 'in' and 'not in' and can be combined with 'and', 'or',
+// 'not' and parentheses.  Addresses can be matched against CIDR ranges and numbers against ranges
+// such as 1024-65535.
+func ParseFilter(expr string) (Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	p := filterParser{tokens: tokens}
+
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("invalid filter: unexpected '%v' at position %v", tok.text, tok.pos)
+	}
+
+	return f, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lexFilter(expr string) ([]token, error) {
+	tokens := make([]token, 0)
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+
+			op := string(runes[start:i])
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at position %v", start)
+			}
+			if op == "=" {
+				op = "=="
+			}
+			tokens = append(tokens, token{tokenOp, op, start})
+
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated string starting at position %v", start)
+			}
+			tokens = append(tokens, token{tokenString, string(runes[start+1 : i]), start})
+			i++
+
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[start:i]), start})
+
+		default:
+			return nil, fmt.Errorf("unexpected character '%c' at position %v", r, i)
+		}
+	}
+
+	return append(tokens, token{tokenEOF, "end of filter", len(runes)}), nil
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-/:", r)
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *filterParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok.kind == tokenWord && strings.EqualFold(tok.text, keyword)
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	f, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []Filter{f}
+
+	for p.peekKeyword("or") {
+		p.next()
+
+		f, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, f)
+	}
+
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+
+	return NewOrFilter(filters...), nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	f, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []Filter{f}
+
+	for p.peekKeyword("and") {
+		p.next()
+
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, f)
+	}
+
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+
+	return NewAndFilter(filters...), nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.peekKeyword("not") {
+		p.next()
+
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return NewNotFilter(f), nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.next()
+
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if tok := p.next(); tok.kind != tokenRParen {
+			return nil, fmt.Errorf("expected ')' at position %v, got '%v'", tok.pos, tok.text)
+		}
+
+		return f, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokenWord {
+		return nil, fmt.Errorf("expected a field name at position %v, got '%v'", fieldTok.pos, fieldTok.text)
+	}
+
+	field, ok := filterFields[strings.ToLower(fieldTok.text)]
+	if !ok {
+		return nil, fmt.Errorf("unknown field '%v' at position %v, valid fields are: %v", fieldTok.text, fieldTok.pos, filterFieldNames())
+	}
+
+	op, err := p.parseOperator()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := p.parseValues(op)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := field.compile(op, values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid comparison for field '%v' at position %v: %w", fieldTok.text, fieldTok.pos, err)
+	}
+
+	return f, nil
+}
+
+func (p *filterParser) parseOperator() (string, error) {
+	tok := p.next()
+
+	switch {
+	case tok.kind == tokenOp:
+		return tok.text, nil
+	case tok.kind == tokenWord && strings.EqualFold(tok.text, "in"):
+		return "in", nil
+	case tok.kind == tokenWord && strings.EqualFold(tok.text, "not"):
+		if !p.peekKeyword("in") {
+			return "", fmt.Errorf("expected 'in' after 'not' at position %v", p.peek().pos)
+		}
+		p.next()
+		return "not in", nil
+	}
+
+	return "", fmt.Errorf("expected an operator at position %v, got '%v'", tok.pos, tok.text)
+}
+
+func (p *filterParser) parseValues(op string) ([]string, error) {
+	if (op == "in" || op == "not in") && p.peek().kind == tokenLParen {
+		p.next()
+		values := make([]string, 0)
+
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+
+			tok := p.next()
+			if tok.kind == tokenRParen {
+				return values, nil
+			}
+			if tok.kind != tokenComma {
+				return nil, fmt.Errorf("expected ',' or ')' at position %v, got '%v'", tok.pos, tok.text)
+			}
+		}
+	}
+
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{v}, nil
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	tok := p.next()
+	if tok.kind != tokenWord && tok.kind != tokenString {
+		return "", fmt.Errorf("expected a value at position %v, got '%v'", tok.pos, tok.text)
+	}
+	return tok.text, nil
+}
+
+// predicateFilter is the leaf node of a parsed filter expression.
+type predicateFilter func(t flowlog.FlowTuple) bool
+
+func (f predicateFilter) Print(t flowlog.FlowTuple) bool {
+	return f(t)
+}
+
+type filterField struct {
+	compile func(op string, values []string) (Filter, error)
+}
+
+var filterFields = map[string]filterField{
+	"nsg":                {stringField(func(t flowlog.FlowTuple) string { return t.NsgName() })},
+	"rule":               {stringField(func(t flowlog.FlowTuple) string { return t.Rule })},
+	"mac":                {stringField(func(t flowlog.FlowTuple) string { return t.Mac })},
+	"src_addr":           {addrField(func(t flowlog.FlowTuple) netip.Addr { return t.SourceAddress })},
+	"dst_addr":           {addrField(func(t flowlog.FlowTuple) netip.Addr { return t.DestAddress })},
+	"src_port":           {numberField(func(t flowlog.FlowTuple) (uint64, bool) { return uint64(t.SourcePort), true })},
+	"dst_port":           {numberField(func(t flowlog.FlowTuple) (uint64, bool) { return uint64(t.DestPort), true })},
+	"protocol":           {enumField(func(t flowlog.FlowTuple) string { return t.Protocol.String() }, "tcp", "udp")},
+	"direction":          {enumField(func(t flowlog.FlowTuple) string { return t.Direction.String() }, "in", "out")},
+	"decision":           {enumField(func(t flowlog.FlowTuple) string { return t.Decision.String() }, "allow", "deny")},
+	"state":              {enumField(func(t flowlog.FlowTuple) string { return t.State.String() }, "begin", "continuing", "end")},
+	"src_to_dst_packets": {numberField(func(t flowlog.FlowTuple) (uint64, bool) { return t.SrcToDestPackets, t.HasTraffic() })},
+	"src_to_dst_bytes":   {numberField(func(t flowlog.FlowTuple) (uint64, bool) { return t.SrcToDestBytes, t.HasTraffic() })},
+	"dst_to_src_packets": {numberField(func(t flowlog.FlowTuple) (uint64, bool) { return t.DestToSrcPackets, t.HasTraffic() })},
+	"dst_to_src_bytes":   {numberField(func(t flowlog.FlowTuple) (uint64, bool) { return t.DestToSrcBytes, t.HasTraffic() })},
+}
+
+func filterFieldNames() string {
+	names := make([]string, 0, len(filterFields))
+	for name := range filterFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// membershipFilter builds the filter for the equality style operators (==, !=, in, not in) from a
+// function that reports whether a tuple matches any of the supplied values.
+func membershipFilter(op string, matchesAny func(t flowlog.FlowTuple) bool) (Filter, error) {
+	switch op {
+	case "==", "in":
+		return predicateFilter(matchesAny), nil
+	case "!=", "not in":
+		return predicateFilter(func(t flowlog.FlowTuple) bool { return !matchesAny(t) }), nil
+	}
+	return nil, fmt.Errorf("operator '%v' is not supported", op)
+}
+
+func stringField(get func(t flowlog.FlowTuple) string) func(string, []string) (Filter, error) {
+	return func(op string, values []string) (Filter, error) {
+		return membershipFilter(op, func(t flowlog.FlowTuple) bool {
+			for _, v := range values {
+				if strings.EqualFold(get(t), v) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+}
+
+func enumField(get func(t flowlog.FlowTuple) string, allowed ...string) func(string, []string) (Filter, error) {
+	return func(op string, values []string) (Filter, error) {
+		for _, v := range values {
+			if !containsFold(allowed, v) {
+				return nil, fmt.Errorf("'%v' is not one of: %v", v, strings.Join(allowed, ", "))
+			}
+		}
+		return stringField(get)(op, values)
+	}
+}
+
+func containsFold(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func addrField(get func(t flowlog.FlowTuple) netip.Addr) func(string, []string) (Filter, error) {
+	return func(op string, values []string) (Filter, error) {
+		prefixes := make([]netip.Prefix, 0, len(values))
+
+		for _, v := range values {
+			p, err := parseAddrOrPrefix(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, p)
+		}
+
+		return membershipFilter(op, func(t flowlog.FlowTuple) bool {
+			for _, p := range prefixes {
+				if p.Contains(get(t)) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+}
+
+func parseAddrOrPrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		p, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range '%v': %w", value, err)
+		}
+		return p.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address '%v': %w", value, err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+type numberRange struct {
+	low, high uint64
+}
+
+func (r numberRange) contains(n uint64) bool {
+	return n >= r.low && n <= r.high
+}
+
+// numberField compiles comparisons against a number that a tuple may not have, such as the traffic
+// counts that are only present in version 2 tuples that have ended or are continuing.  A tuple without
+// the value never matches, whatever the operator, so 'src_to_dst_bytes != 0' only matches tuples with
+// traffic counts.
+func numberField(value func(t flowlog.FlowTuple) (uint64, bool)) func(string, []string) (Filter, error) {
+	return func(op string, values []string) (Filter, error) {
+		ranges := make([]numberRange, 0, len(values))
+
+		for _, v := range values {
+			r, err := parseNumberRange(v)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+		}
+
+		var f Filter
+
+		switch op {
+		case "<", "<=", ">", ">=":
+			if len(ranges) != 1 || ranges[0].low != ranges[0].high {
+				return nil, fmt.Errorf("operator '%v' requires a single number", op)
+			}
+			f = numberComparison(op, ranges[0].low, value)
+
+		default:
+			var err error
+			f, err = membershipFilter(op, func(t flowlog.FlowTuple) bool {
+				n, _ := value(t)
+				for _, r := range ranges {
+					if r.contains(n) {
+						return true
+					}
+				}
+				return false
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return predicateFilter(func(t flowlog.FlowTuple) bool {
+			_, ok := value(t)
+			return ok && f.Print(t)
+		}), nil
+	}
+}
+
+func numberComparison(op string, limit uint64, value func(t flowlog.FlowTuple) (uint64, bool)) Filter {
+	return predicateFilter(func(t flowlog.FlowTuple) bool {
+		n, _ := value(t)
+
+		switch op {
+		case "<":
+			return n < limit
+		case "<=":
+			return n <= limit
+		case ">":
+			return n > limit
+		default:
+			return n >= limit
+		}
+	})
+}
+
+func parseNumberRange(value string) (numberRange, error) {
+	lowStr, highStr, isRange := strings.Cut(value, "-")
+
+	low, err := strconv.ParseUint(lowStr, 10, 64)
+	if err != nil {
+		return numberRange{}, fmt.Errorf("invalid number '%v'", value)
+	}
+
+	if !isRange {
+		return numberRange{low, low}, nil
+	}
+
+	high, err := strconv.ParseUint(highStr, 10, 64)
+	if err != nil || high < low {
+		return numberRange{}, fmt.Errorf("invalid range '%v'", value)
+	}
+
+	return numberRange{low, high}, nil
+}
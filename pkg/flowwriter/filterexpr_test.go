@@ -0,0 +1,154 @@
+package flowwriter
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+var filterTestTuples = map[string]flowlog.FlowTuple{
+	"httpsOut": {
+		Time: time.Unix(1660039344, 0), Version: 2, Rule: "DefaultRule_AllowInternetOutBound",
+		SourceAddress: netip.MustParseAddr("10.0.0.4"), SourcePort: 50276, DestAddress: netip.MustParseAddr("51.104.229.52"), DestPort: 443,
+		Protocol: flowlog.ProtocolTcp, Direction: flowlog.DirectionOutbound, Decision: flowlog.DecisionAllow, State: flowlog.StateEnd,
+		SrcToDestPackets: 14, SrcToDestBytes: 2839, DestToSrcPackets: 14, DestToSrcBytes: 5801,
+	},
+	"telnetIn": {
+		Time: time.Unix(1660039356, 0), Version: 2, Rule: "DefaultRule_DenyAllInBound",
+		SourceAddress: netip.MustParseAddr("117.88.229.255"), SourcePort: 50996, DestAddress: netip.MustParseAddr("10.0.0.4"), DestPort: 23,
+		Protocol: flowlog.ProtocolTcp, Direction: flowlog.DirectionInbound, Decision: flowlog.DecisionDeny, State: flowlog.StateBegin,
+	},
+	"sshIn": {
+		Time: time.Unix(1660039351, 0), Version: 2, Rule: "UserRule_ssh",
+		ResourceId:    "/SUBSCRIPTIONS/XYZ/RESOURCEGROUPS/RG/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-HUB",
+		SourceAddress: netip.MustParseAddr("38.88.252.187"), SourcePort: 59246, DestAddress: netip.MustParseAddr("10.0.0.4"), DestPort: 22,
+		Protocol: flowlog.ProtocolTcp, Direction: flowlog.DirectionInbound, Decision: flowlog.DecisionAllow, State: flowlog.StateBegin,
+	},
+	"v6": {
+		Time: time.Unix(1660039351, 0), Version: 1, Rule: "UserRule_ssh",
+		SourceAddress: netip.MustParseAddr("fd00::1"), SourcePort: 59246, DestAddress: netip.MustParseAddr("fd00::2"), DestPort: 22,
+		Protocol: flowlog.ProtocolUdp, Direction: flowlog.DirectionInbound, Decision: flowlog.DecisionAllow,
+	},
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"decision == deny", []string{"telnetIn"}},
+		{"decision != deny", []string{"httpsOut", "sshIn", "v6"}},
+		{"src_addr in 10.0.0.0/8 and dst_port == 443 and decision == allow", []string{"httpsOut"}},
+		{"dst_addr == 10.0.0.4", []string{"telnetIn", "sshIn"}},
+		{"dst_addr not in (10.0.0.0/24, fd00::/64)", []string{"httpsOut"}},
+		{"dst_port in 1-1023", []string{"httpsOut", "telnetIn", "sshIn", "v6"}},
+		{"dst_port in (22, 8000-9000)", []string{"sshIn", "v6"}},
+		{"dst_port < 100 and not rule == userrule_ssh", []string{"telnetIn"}},
+		{"direction = in and (state == begin or src_addr in fd00::/8)", []string{"telnetIn", "sshIn", "v6"}},
+		{"src_to_dst_bytes >= 1000", []string{"httpsOut"}},
+		{"dst_to_src_packets < 100", []string{"httpsOut"}},
+		{"src_to_dst_bytes != 0", []string{"httpsOut"}},
+		{"not src_to_dst_bytes > 0", []string{"telnetIn", "sshIn", "v6"}},
+		{"protocol == udp", []string{"v6"}},
+		{"rule == 'UserRule_ssh' or DECISION == deny", []string{"telnetIn", "sshIn", "v6"}},
+		{"not (direction == in)", []string{"httpsOut"}},
+		{"nsg == nsg-hub", []string{"sshIn"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := ParseFilter(tt.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			wanted := make(map[string]bool)
+			for _, name := range tt.want {
+				wanted[name] = true
+			}
+
+			for name, tuple := range filterTestTuples {
+				if got := f.Print(tuple); got != wanted[name] {
+					t.Errorf("unexpected result for tuple %v. want: %v, got: %v", name, wanted[name], got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFilterMissingTrafficCounts(t *testing.T) {
+	withTraffic := filterTestTuples["httpsOut"]
+	without := []string{"telnetIn", "sshIn", "v6"}
+
+	fields := []string{"src_to_dst_packets", "src_to_dst_bytes", "dst_to_src_packets", "dst_to_src_bytes"}
+
+	// every operator, with whether it matches the tuple whose counts are all between 14 and 5801
+	comparisons := []struct {
+		comparison string
+		want       bool
+	}{
+		{"== 0", false},
+		{"!= 0", true},
+		{"in (0, 1-5)", false},
+		{"not in (0, 1-5)", true},
+		{"< 10000", true},
+		{"<= 0", false},
+		{"> 0", true},
+		{">= 10000", false},
+	}
+
+	for _, field := range fields {
+		for _, c := range comparisons {
+			expr := field + " " + c.comparison
+
+			t.Run(expr, func(t *testing.T) {
+				f, err := ParseFilter(expr)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if got := f.Print(withTraffic); got != c.want {
+					t.Errorf("unexpected result for tuple with traffic counts. want: %v, got: %v", c.want, got)
+				}
+
+				for _, name := range without {
+					if f.Print(filterTestTuples[name]) {
+						t.Errorf("tuple %v without traffic counts matched", name)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	exprs := []string{
+		"",
+		"decision",
+		"decision ==",
+		"decision == maybe",
+		"colour == red",
+		"src_addr in 10.0.0.0/33",
+		"src_addr > 10.0.0.4",
+		"dst_port < 10-20",
+		"dst_port in 20-10",
+		"dst_port == abc",
+		"(decision == deny",
+		"decision == deny)",
+		"decision == deny and",
+		"rule == 'unterminated",
+		"decision ! deny",
+		"dst_port in (22 80)",
+		"src_port not 22",
+	}
+
+	for _, expr := range exprs {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseFilter(expr); err == nil {
+				t.Errorf("expected error parsing filter '%v'", expr)
+			}
+		})
+	}
+}
@@ -1,24 +1,62 @@
 package flowwriter
 
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
 type FlowWriter interface {
-	WriteFlowBlock(data []byte) error
-	Flush()
-	AddFilter(f filter)
+	WriteFlowTuples(tuples []flowlog.FlowTuple) error
+	// Flush writes any tuples held back by the sort window, returning the first error writing them.
+	Flush() error
+	AddFilter(f Filter)
+	SetSortWindow(d time.Duration)
+}
+
+type Filter interface {
+	Print(t flowlog.FlowTuple) bool
+}
+
+// flushWriter flushes w if it buffers its output.
+func flushWriter(w io.Writer) error {
+	if f, ok := w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
 }
 
-type filter interface {
-	Print(t flowTuple) bool
+// errWriter remembers the first error writing to w, for writers such as tablewriter's that drop them.
+type errWriter struct {
+	w   io.Writer
+	err error
 }
 
-func getFlowTuples(fb *flowLogBlock) (tuples []flowTuple) {
-	for _, flowGroup := range fb.Properties.Flows {
-		for _, flow := range flowGroup.Flows {
-			for _, t := range flow.FlowTuples {
-				t.Rule = flowGroup.Rule
-				tuples = append(tuples, t)
-			}
-		}
+func (e *errWriter) Write(p []byte) (int, error) {
+	if e.err != nil {
+		return 0, e.err
+	}
+
+	n, err := e.w.Write(p)
+	if err != nil {
+		e.err = err
+	}
+	return n, err
+}
+
+var columnHeaders = []string{"time", "nsg", "rule", "src_addr", "src_port", "dst_addr", "dst_port", "direction", "decision", "state", "src_to_dst_bytes", "dst_to_src_bytes"}
+
+// tupleColumns formats a tuple as the columns named in columnHeaders.
+func tupleColumns(t flowlog.FlowTuple) []string {
+	var srcToDestBytes, destToSrcBytes string
+	if t.HasTraffic() {
+		srcToDestBytes = strconv.FormatUint(t.SrcToDestBytes, 10)
+		destToSrcBytes = strconv.FormatUint(t.DestToSrcBytes, 10)
 	}
 
-	return
+	return []string{t.Time.Format(time.StampMilli), t.NsgName(), t.Rule, t.SourceAddress.String(), strconv.Itoa(int(t.SourcePort)),
+		t.DestAddress.String(), strconv.Itoa(int(t.DestPort)), t.Direction.String(), t.Decision.String(), t.State.String(),
+		srcToDestBytes, destToSrcBytes}
 }
@@ -0,0 +1,117 @@
+package flowwriter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+type JsonLinesWriter struct {
+	w       io.Writer
+	encoder *json.Encoder
+	buffer  tupleBuffer
+	filters FilterChain
+}
+
+func NewJsonLinesWriter(w io.Writer) *JsonLinesWriter {
+	return &JsonLinesWriter{
+		w:       w,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (j *JsonLinesWriter) AddFilter(f Filter) {
+	j.filters.Add(f)
+}
+
+func (j *JsonLinesWriter) SetSortWindow(d time.Duration) {
+	j.buffer.window = d
+}
+
+func (j *JsonLinesWriter) WriteFlowBlock(data []byte) error {
+	fb, err := flowlog.ParseBlock(data)
+	if err != nil {
+		return fmt.Errorf("unable to decode flow log block: %w \n%v", err, string(data))
+	}
+
+	return j.WriteFlowTuples(fb.Tuples())
+}
+
+func (j *JsonLinesWriter) WriteFlowTuples(tuples []flowlog.FlowTuple) error {
+	j.buffer.add(j.filters.apply(tuples))
+	return j.writeTuples(j.buffer.ready())
+}
+
+func (j *JsonLinesWriter) Flush() error {
+	if err := j.writeTuples(j.buffer.drain()); err != nil {
+		return err
+	}
+	return flushWriter(j.w)
+}
+
+// Close closes the underlying writer if it is an io.Closer.  Call Flush first to write buffered tuples.
+func (j *JsonLinesWriter) Close() error {
+	if closer, ok := j.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (j *JsonLinesWriter) writeTuples(tuples []flowlog.FlowTuple) error {
+	for _, t := range tuples {
+		if err := j.encoder.Encode(newJsonFlowTuple(t)); err != nil {
+			return fmt.Errorf("failed to write json line: %w", err)
+		}
+	}
+	return nil
+}
+
+type jsonFlowTuple struct {
+	Time             string     `json:"time"`
+	Nsg              string     `json:"nsg"`
+	Rule             string     `json:"rule"`
+	Mac              string     `json:"mac"`
+	SourceAddress    netip.Addr `json:"src_addr"`
+	SourcePort       uint16     `json:"src_port"`
+	DestAddress      netip.Addr `json:"dst_addr"`
+	DestPort         uint16     `json:"dst_port"`
+	Protocol         string     `json:"protocol,omitempty"`
+	Direction        string     `json:"direction"`
+	Decision         string     `json:"decision"`
+	State            string     `json:"state,omitempty"`
+	SrcToDestPackets *uint64    `json:"src_to_dst_packets,omitempty"`
+	SrcToDestBytes   *uint64    `json:"src_to_dst_bytes,omitempty"`
+	DestToSrcPackets *uint64    `json:"dst_to_src_packets,omitempty"`
+	DestToSrcBytes   *uint64    `json:"dst_to_src_bytes,omitempty"`
+}
+
+func newJsonFlowTuple(t flowlog.FlowTuple) jsonFlowTuple {
+	jt := jsonFlowTuple{
+		Time:          t.Time.Format(time.RFC3339),
+		Nsg:           t.NsgName(),
+		Rule:          t.Rule,
+		Mac:           t.Mac,
+		SourceAddress: t.SourceAddress,
+		SourcePort:    t.SourcePort,
+		DestAddress:   t.DestAddress,
+		DestPort:      t.DestPort,
+		Protocol:      t.Protocol.String(),
+		Direction:     t.Direction.String(),
+		Decision:      t.Decision.String(),
+		State:         t.State.String(),
+	}
+
+	// traffic counts are omitted rather than written as zero when the tuple doesn't record them
+	if t.HasTraffic() {
+		jt.SrcToDestPackets = &t.SrcToDestPackets
+		jt.SrcToDestBytes = &t.SrcToDestBytes
+		jt.DestToSrcPackets = &t.DestToSrcPackets
+		jt.DestToSrcBytes = &t.DestToSrcBytes
+	}
+
+	return jt
+}
@@ -0,0 +1,60 @@
+package flowwriter
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJsonLinesWriter(t *testing.T) {
+	var buffer bytes.Buffer
+	jsonWriter := NewJsonLinesWriter(&buffer)
+
+	err := jsonWriter.WriteFlowBlock([]byte(csvWriterTestFlows))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonWriter.Flush()
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+
+	t.Run("WritesOneObjectPerTuple", func(t *testing.T) {
+		if len(lines) != len(wantedCsvFileLines) {
+			t.Fatalf("unexpected number of lines. want: %v, got: %v", len(wantedCsvFileLines), len(lines))
+		}
+
+		for _, l := range lines {
+			var obj map[string]interface{}
+			if err := json.Unmarshal([]byte(l), &obj); err != nil {
+				t.Errorf("line is not a valid json object: %v", l)
+			}
+		}
+	})
+
+	t.Run("WritesTypedFields", func(t *testing.T) {
+		want := `{"time":"2022-08-09T10:02:24Z","nsg":"NSG-VIEW","rule":"DefaultRule_AllowInternetOutBound","mac":"000D3AD488D1","src_addr":"10.0.0.4","src_port":50276,"dst_addr":"51.104.229.52","dst_port":443,"protocol":"tcp","direction":"out","decision":"allow","state":"end","src_to_dst_packets":14,"src_to_dst_bytes":2839,"dst_to_src_packets":14,"dst_to_src_bytes":5801}`
+		for _, l := range lines {
+			if strings.Contains(l, `"src_port":50276`) && l != want {
+				t.Errorf("unexpected line.\nwant: %v\ngot:  %v", want, l)
+			}
+		}
+	})
+
+	t.Run("OmitsMissingByteCounts", func(t *testing.T) {
+		for _, l := range lines {
+			if strings.Contains(l, `"dst_port":23`) && strings.Contains(l, "bytes") {
+				t.Errorf("expected byte counts to be omitted: %v", l)
+			}
+		}
+	})
+
+	t.Run("DoesNotRewriteFlushedTuples", func(t *testing.T) {
+		buffer.Reset()
+		jsonWriter.Flush()
+
+		if buffer.Len() != 0 {
+			t.Errorf("expected nothing to be written on second flush, got: %v", buffer.String())
+		}
+	})
+}
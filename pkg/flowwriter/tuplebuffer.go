@@ -0,0 +1,76 @@
+package flowwriter
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+// tupleBuffer holds tuples until they can be written in time order.  With no window every tuple is
+// held until the buffer is drained, otherwise tuples are released as soon as they are older than the
+// newest tuple seen by more than the window so memory use is bounded by the window rather than the
+// size of the whole result set.
+type tupleBuffer struct {
+	window time.Duration
+	newest time.Time
+	tuples tupleHeap
+}
+
+func (b *tupleBuffer) add(tuples []flowlog.FlowTuple) {
+	for _, t := range tuples {
+		heap.Push(&b.tuples, t)
+
+		if t.Time.After(b.newest) {
+			b.newest = t.Time
+		}
+	}
+}
+
+// ready removes and returns the tuples that are old enough to be written.
+func (b *tupleBuffer) ready() []flowlog.FlowTuple {
+	if b.window <= 0 {
+		return nil
+	}
+
+	cutoff := b.newest.Add(-b.window)
+	var tuples []flowlog.FlowTuple
+
+	for b.tuples.Len() > 0 && b.tuples[0].Time.Before(cutoff) {
+		tuples = append(tuples, heap.Pop(&b.tuples).(flowlog.FlowTuple))
+	}
+
+	return tuples
+}
+
+// drain removes and returns every tuple in the buffer.
+func (b *tupleBuffer) drain() []flowlog.FlowTuple {
+	tuples := make([]flowlog.FlowTuple, 0, b.tuples.Len())
+
+	for b.tuples.Len() > 0 {
+		tuples = append(tuples, heap.Pop(&b.tuples).(flowlog.FlowTuple))
+	}
+
+	b.tuples = nil
+	return tuples
+}
+
+type tupleHeap []flowlog.FlowTuple
+
+func (h tupleHeap) Len() int { return len(h) }
+
+func (h tupleHeap) Less(i, j int) bool { return h[i].Time.Before(h[j].Time) }
+
+func (h tupleHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *tupleHeap) Push(x interface{}) {
+	*h = append(*h, x.(flowlog.FlowTuple))
+}
+
+func (h *tupleHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	*h = old[:n-1]
+	return t
+}
@@ -0,0 +1,65 @@
+package flowwriter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+func tuplesAt(minutes ...int) []flowlog.FlowTuple {
+	start := time.Date(2022, 8, 9, 10, 0, 0, 0, time.UTC)
+	tuples := make([]flowlog.FlowTuple, 0, len(minutes))
+	for _, m := range minutes {
+		tuples = append(tuples, flowlog.FlowTuple{Time: start.Add(time.Duration(m) * time.Minute)})
+	}
+	return tuples
+}
+
+func assertTupleOrder(t *testing.T, tuples []flowlog.FlowTuple, want ...int) {
+	t.Helper()
+	wanted := tuplesAt(want...)
+
+	if len(tuples) != len(wanted) {
+		t.Fatalf("unexpected number of tuples. want: %v, got: %v", len(wanted), len(tuples))
+	}
+
+	for i := range wanted {
+		if !tuples[i].Time.Equal(wanted[i].Time) {
+			t.Errorf("unexpected tuple at index %v. want: %v, got: %v", i, wanted[i].Time, tuples[i].Time)
+		}
+	}
+}
+
+func TestTupleBuffer(t *testing.T) {
+	t.Run("HoldsEverythingWithoutAWindow", func(t *testing.T) {
+		var b tupleBuffer
+		b.add(tuplesAt(30, 0, 10))
+
+		if ready := b.ready(); len(ready) != 0 {
+			t.Errorf("expected no tuples to be ready, got %v", len(ready))
+		}
+
+		assertTupleOrder(t, b.drain(), 0, 10, 30)
+	})
+
+	t.Run("ReleasesTuplesOlderThanTheWindow", func(t *testing.T) {
+		b := tupleBuffer{window: 5 * time.Minute}
+		b.add(tuplesAt(3, 0, 1))
+		assertTupleOrder(t, b.ready())
+
+		b.add(tuplesAt(7, 2))
+		assertTupleOrder(t, b.ready(), 0, 1)
+
+		b.add(tuplesAt(20))
+		assertTupleOrder(t, b.ready(), 2, 3, 7)
+		assertTupleOrder(t, b.drain(), 20)
+	})
+
+	t.Run("DrainEmptiesTheBuffer", func(t *testing.T) {
+		var b tupleBuffer
+		b.add(tuplesAt(1, 2))
+		b.drain()
+		assertTupleOrder(t, b.drain())
+	})
+}
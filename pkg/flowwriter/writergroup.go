@@ -1,7 +1,29 @@
 package flowwriter
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+// ParseErrorPolicy controls how a WriterGroup handles blocks and tuples it can't parse.
+type ParseErrorPolicy int
+
+const (
+	FailOnParseError ParseErrorPolicy = iota
+	WarnOnParseError
+	SkipOnParseError
+)
+
 type WriterGroup struct {
-	writers []FlowWriter
+	writers          []FlowWriter
+	filters          []Filter
+	parseErrorPolicy ParseErrorPolicy
+	sortWindow       time.Duration
 }
 
 func NewWriterGroup(w ...FlowWriter) *WriterGroup {
@@ -10,9 +32,62 @@ func NewWriterGroup(w ...FlowWriter) *WriterGroup {
 	}
 }
 
+func (wg *WriterGroup) SetParseErrorPolicy(p ParseErrorPolicy) {
+	wg.parseErrorPolicy = p
+}
+
+// WriteFlowBlock parses a flow log block once and writes its tuples to every writer in the group.
+// Malformed tuples are handled according to the group's ParseErrorPolicy.
 func (wg *WriterGroup) WriteFlowBlock(data []byte) error {
+	tuples, err := DecodeFlowBlock(data, wg.parseErrorPolicy)
+	if err != nil {
+		return err
+	}
+
+	return wg.WriteFlowTuples(tuples)
+}
+
+// DecodeFlowBlock parses a flow log block and returns its tuples, handling malformed tuples and blocks
+// according to policy.
+func DecodeFlowBlock(data []byte, policy ParseErrorPolicy) ([]flowlog.FlowTuple, error) {
+	fb, err := flowlog.ParseBlock(data)
+	if err != nil {
+		if err := handleParseError(err, data, policy); err != nil {
+			return nil, err
+		}
+
+		// the block couldn't be decoded at all so there are no tuples to write
+		if fb == nil {
+			return nil, nil
+		}
+	}
+
+	return fb.Tuples(), nil
+}
+
+func handleParseError(err error, data []byte, policy ParseErrorPolicy) error {
+	switch policy {
+	case SkipOnParseError:
+		return nil
+
+	case WarnOnParseError:
+		var blockErr *flowlog.BlockError
+		if errors.As(err, &blockErr) {
+			for _, te := range blockErr.TupleErrors {
+				log.Printf("skipping tuple: %v", te)
+			}
+		} else {
+			log.Printf("skipping flow log block: %v", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unable to decode flow log block: %w \n%v", err, string(data))
+}
+
+func (wg *WriterGroup) WriteFlowTuples(tuples []flowlog.FlowTuple) error {
 	for _, w := range wg.writers {
-		err := w.WriteFlowBlock(data)
+		err := w.WriteFlowTuples(tuples)
 		if err != nil {
 			return err
 		}
@@ -20,18 +95,68 @@ func (wg *WriterGroup) WriteFlowBlock(data []byte) error {
 	return nil
 }
 
-func (wg *WriterGroup) AddWriter(w FlowWriter) {
+// AddWriter adds a writer to the group.  The writer receives the group's sort window and every filter
+// already added to the group as well as the supplied filters, which only apply to this writer.
+func (wg *WriterGroup) AddWriter(w FlowWriter, filters ...Filter) {
+	w.SetSortWindow(wg.sortWindow)
+	for _, f := range wg.filters {
+		w.AddFilter(f)
+	}
+	for _, f := range filters {
+		w.AddFilter(f)
+	}
 	wg.writers = append(wg.writers, w)
 }
 
-func (wg *WriterGroup) Flush() {
+// Flush flushes every writer in the group, returning the first error encountered.
+func (wg *WriterGroup) Flush() error {
+	var err error
+	for _, w := range wg.writers {
+		if ferr := w.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("failed to flush writer: %w", ferr)
+		}
+	}
+	return err
+}
+
+// Close flushes every writer in the group and then closes those that are io.Closers.  A failed flush
+// is returned ahead of any close error, which is included in its message.
+func (wg *WriterGroup) Close() error {
+	flushErr := wg.Flush()
+
+	var closeErr error
 	for _, w := range wg.writers {
-		w.Flush()
+		if c, ok := w.(io.Closer); ok {
+			if err := c.Close(); err != nil && closeErr == nil {
+				closeErr = fmt.Errorf("failed to close writer: %w", err)
+			}
+		}
+	}
+
+	switch {
+	case flushErr != nil && closeErr != nil:
+		return fmt.Errorf("%w (also %v)", flushErr, closeErr)
+	case flushErr != nil:
+		return flushErr
+	default:
+		return closeErr
 	}
 }
 
-func (wg *WriterGroup) AddFilter(f filter) {
+// AddFilter adds a filter to every writer in the group, including writers added later.
+func (wg *WriterGroup) AddFilter(f Filter) {
+	wg.filters = append(wg.filters, f)
 	for _, w := range wg.writers {
 		w.AddFilter(f)
 	}
 }
+
+// SetSortWindow sets the sort window of every writer in the group, including writers added later.
+// Writers hold tuples until they are older than the newest tuple seen by more than the window so
+// that output is written incrementally in time order.  A zero window holds every tuple until Flush.
+func (wg *WriterGroup) SetSortWindow(d time.Duration) {
+	wg.sortWindow = d
+	for _, w := range wg.writers {
+		w.SetSortWindow(d)
+	}
+}
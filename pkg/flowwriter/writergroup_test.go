@@ -1,22 +1,50 @@
 package flowwriter
 
-import "testing"
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
 
 type fakeWriter struct {
-	writtenBlocks [][]byte
+	writtenTuples [][]flowlog.FlowTuple
 	flushCount    int
+	flushErr      error
+	filters       []Filter
+	sortWindow    time.Duration
 }
 
-func (fw *fakeWriter) WriteFlowBlock(data []byte) error {
-	fw.writtenBlocks = append(fw.writtenBlocks, data)
+func (fw *fakeWriter) WriteFlowTuples(tuples []flowlog.FlowTuple) error {
+	fw.writtenTuples = append(fw.writtenTuples, tuples)
 	return nil
 }
 
-func (fw *fakeWriter) Flush() {
+func (fw *fakeWriter) Flush() error {
 	fw.flushCount++
+	return fw.flushErr
+}
+
+func (fw *fakeWriter) AddFilter(f Filter) {
+	fw.filters = append(fw.filters, f)
 }
 
-func (fw *fakeWriter) AddFilter(f filter) {}
+func (fw *fakeWriter) SetSortWindow(d time.Duration) {
+	fw.sortWindow = d
+}
+
+type closingFakeWriter struct {
+	fakeWriter
+	closed   bool
+	closeErr error
+}
+
+func (cw *closingFakeWriter) Close() error {
+	cw.closed = true
+	return cw.closeErr
+}
 
 var (
 	writer1 *fakeWriter
@@ -28,24 +56,24 @@ func TestWriterGroup(t *testing.T) {
 	writer1 = new(fakeWriter)
 	writer2 = new(fakeWriter)
 	wg = *NewWriterGroup(writer1, writer2)
-	data1 := "abc123"
-	data2 := "321cba"
+	data1 := `{"properties":{"Version":1,"flows":[{"rule":"abc123","flows":[{"flowTuples":["1660039344,10.0.0.4,10.0.0.5,50276,443,T,O,A"]}]}]}}`
+	data2 := `,{"properties":{"Version":1,"flows":[{"rule":"321cba","flows":[{"flowTuples":["1660039345,10.0.0.4,10.0.0.5,50276,443,T,O,A"]}]}]}}`
 
 	t.Run("WritesDataToAllWriters", func(t *testing.T) {
 		wg.WriteFlowBlock([]byte(data1))
 		wg.WriteFlowBlock([]byte(data2))
 
 		for _, w := range []fakeWriter{*writer1, *writer2} {
-			if len(w.writtenBlocks) != 2 {
-				t.Fatalf("expected writer to have 2 blocks written, got %v", len(w.writtenBlocks))
+			if len(w.writtenTuples) != 2 {
+				t.Fatalf("expected writer to have 2 blocks written, got %v", len(w.writtenTuples))
 			}
 
-			if string(w.writtenBlocks[0]) != data1 {
-				t.Errorf("expected first written block to be %v, got %v", data1, string(w.writtenBlocks[0]))
+			if w.writtenTuples[0][0].Rule != "abc123" {
+				t.Errorf("expected first written block to be from rule abc123, got %v", w.writtenTuples[0])
 			}
 
-			if string(w.writtenBlocks[1]) != data2 {
-				t.Errorf("expected first written block to be %v, got %v", data2, string(w.writtenBlocks[1]))
+			if w.writtenTuples[1][0].Rule != "321cba" {
+				t.Errorf("expected second written block to be from rule 321cba, got %v", w.writtenTuples[1])
 			}
 		}
 	})
@@ -60,4 +88,134 @@ func TestWriterGroup(t *testing.T) {
 			}
 		}
 	})
+
+	t.Run("CloseFlushesAndClosesWriters", func(t *testing.T) {
+		closing := new(closingFakeWriter)
+		plain := new(fakeWriter)
+
+		if err := NewWriterGroup(closing, plain).Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if closing.flushCount != 1 || plain.flushCount != 1 {
+			t.Errorf("expected writers to be flushed before closing")
+		}
+
+		if !closing.closed {
+			t.Errorf("expected closeable writer to be closed")
+		}
+	})
+
+	t.Run("CloseReturnsFlushAndCloseErrors", func(t *testing.T) {
+		flushErr, closeErr := errors.New("disk full"), errors.New("bad file descriptor")
+		failing := &closingFakeWriter{fakeWriter: fakeWriter{flushErr: flushErr}, closeErr: closeErr}
+
+		err := NewWriterGroup(failing).Close()
+		if !errors.Is(err, flushErr) {
+			t.Fatalf("expected flush error, got %v", err)
+		}
+		if !strings.Contains(err.Error(), closeErr.Error()) {
+			t.Errorf("expected close error to be reported too, got %v", err)
+		}
+		if !failing.closed {
+			t.Errorf("expected writer to be closed despite failing to flush")
+		}
+	})
+
+	t.Run("CloseReturnsCloseErrorAfterSuccessfulFlush", func(t *testing.T) {
+		closeErr := errors.New("bad file descriptor")
+
+		if err := NewWriterGroup(&closingFakeWriter{closeErr: closeErr}).Close(); !errors.Is(err, closeErr) {
+			t.Errorf("expected close error, got %v", err)
+		}
+	})
+}
+
+func TestWriterGroupFilters(t *testing.T) {
+	groupFilter := staticFilter(true)
+	writerFilter := staticFilter(false)
+	first := new(fakeWriter)
+	second := new(fakeWriter)
+
+	group := NewWriterGroup(first)
+	group.AddFilter(groupFilter)
+	group.AddWriter(second, writerFilter)
+
+	t.Run("GroupFiltersApplyToAllWriters", func(t *testing.T) {
+		for _, w := range []*fakeWriter{first, second} {
+			if len(w.filters) == 0 || w.filters[0] != groupFilter {
+				t.Errorf("expected writer to have group filter, got %v", w.filters)
+			}
+		}
+	})
+
+	t.Run("WriterFiltersOnlyApplyToTheirWriter", func(t *testing.T) {
+		if len(first.filters) != 1 {
+			t.Errorf("expected first writer to have 1 filter, got %v", len(first.filters))
+		}
+
+		if len(second.filters) != 2 || second.filters[1] != writerFilter {
+			t.Errorf("expected second writer to have group and writer filters, got %v", second.filters)
+		}
+	})
+}
+
+func TestWriterGroupParseErrorPolicy(t *testing.T) {
+	malformedBlock := `{"properties":{"Version":2,"flows":[{"rule":"r","flows":[{"flowTuples":["1660039344,10.0.0.4,10.0.0.5,50276,443,T,O,A,B,,,,","1660039344,10.0.0.4,10.0.0.5,50276"]}]}]}}`
+	invalidBlock := `{"properties":`
+
+	setup := func(p ParseErrorPolicy) (*WriterGroup, *fakeWriter) {
+		w := new(fakeWriter)
+		group := NewWriterGroup(w)
+		group.SetParseErrorPolicy(p)
+		return group, w
+	}
+
+	t.Run("FailReturnsError", func(t *testing.T) {
+		group, w := setup(FailOnParseError)
+
+		var blockErr *flowlog.BlockError
+		if err := group.WriteFlowBlock([]byte(malformedBlock)); !errors.As(err, &blockErr) {
+			t.Errorf("expected block error, got %v", err)
+		}
+
+		if len(w.writtenTuples) != 0 {
+			t.Errorf("expected no tuples to be written, got %v", w.writtenTuples)
+		}
+	})
+
+	for _, p := range []ParseErrorPolicy{WarnOnParseError, SkipOnParseError} {
+		group, w := setup(p)
+
+		t.Run("WritesValidTuples", func(t *testing.T) {
+			if err := group.WriteFlowBlock([]byte(malformedBlock)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if len(w.writtenTuples) != 1 || len(w.writtenTuples[0]) != 1 {
+				t.Errorf("expected the valid tuple to be written, got %v", w.writtenTuples)
+			}
+		})
+
+		t.Run("IgnoresInvalidBlocks", func(t *testing.T) {
+			if err := group.WriteFlowBlock([]byte(invalidBlock)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriterGroupSortWindow(t *testing.T) {
+	first := new(fakeWriter)
+	second := new(fakeWriter)
+
+	group := NewWriterGroup(first)
+	group.SetSortWindow(time.Minute)
+	group.AddWriter(second)
+
+	for _, w := range []*fakeWriter{first, second} {
+		if w.sortWindow != time.Minute {
+			t.Errorf("expected writer sort window to be %v, got %v", time.Minute, w.sortWindow)
+		}
+	}
 }
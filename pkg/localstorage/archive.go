@@ -0,0 +1,89 @@
+package localstorage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extractTar extracts the regular files in a tar or tar.gz archive to a new temporary directory and
+// returns its path.
+func extractTar(archivePath string) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive %v: %w", archivePath, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if !strings.HasSuffix(strings.ToLower(archivePath), ".tar") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress archive %v: %w", archivePath, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	dir, err := os.MkdirTemp("", "nsgpeek-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create directory to extract archive to: %w", err)
+	}
+
+	if err := extractTarFiles(tar.NewReader(r), dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to extract archive %v: %w", archivePath, err)
+	}
+
+	return dir, nil
+}
+
+func extractTarFiles(tr *tar.Reader, dir string) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// cleaning the name as an absolute path stops entries escaping the directory
+		dest := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+hdr.Name)))
+
+		if err := writeFile(dest, tr); err != nil {
+			return err
+		}
+
+		if err := os.Chtimes(dest, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+func writeFile(dest string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,112 @@
+package localstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/tmeadon/nsgpeek/pkg/azure"
+)
+
+var errNotFlowLog = errors.New(`expected a flow log file starting with {"records":[`)
+
+// fileBlob reads a downloaded PT1H.json file as if it were the block blob it came from, with an opening
+// block, one block for each record and a closing block.
+type fileBlob struct {
+	fsys fs.FS
+	path string
+	url  string
+}
+
+func (b *fileBlob) URL() string {
+	return b.url
+}
+
+func (b *fileBlob) GetBlocks(ctx context.Context) ([]azure.BlobBlock, error) {
+	f, err := b.fsys.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return splitRecords(f, info.Size())
+}
+
+// splitRecords divides a flow log file of the given size into blocks at the boundaries between records.
+// Each record's block starts with the comma separating it from the previous record, as blocks written
+// by Azure do.
+func splitRecords(r io.Reader, size int64) ([]azure.BlobBlock, error) {
+	dec := json.NewDecoder(r)
+
+	if err := expectToken(dec, json.Delim('{')); err != nil {
+		return nil, err
+	}
+	if err := expectToken(dec, "records"); err != nil {
+		return nil, err
+	}
+	if err := expectToken(dec, json.Delim('[')); err != nil {
+		return nil, err
+	}
+
+	start := dec.InputOffset()
+	blocks := []azure.BlobBlock{{Name: "open", Size: start}}
+
+	for i := 0; dec.More(); i++ {
+		var record json.RawMessage
+		if err := dec.Decode(&record); err != nil {
+			return nil, fmt.Errorf("failed to read record %v: %w", i, err)
+		}
+
+		end := dec.InputOffset()
+		blocks = append(blocks, azure.BlobBlock{Name: fmt.Sprintf("record-%v", i), Size: end - start})
+		start = end
+	}
+
+	return append(blocks, azure.BlobBlock{Name: "close", Size: size - start}), nil
+}
+
+func expectToken(dec *json.Decoder, want json.Token) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("%v: %w", errNotFlowLog, err)
+	}
+	if tok != want {
+		return errNotFlowLog
+	}
+	return nil
+}
+
+func (b *fileBlob) ReadBlock(ctx context.Context, block *azure.BlobBlock, blockIndex int64) ([]byte, error) {
+	f, err := b.fsys.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// files in compressed archives can't seek so are read up to the block instead
+	if s, ok := f.(io.Seeker); ok {
+		_, err = s.Seek(blockIndex, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, f, blockIndex)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, block.Size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+
+	// records may be separated by whitespace as well as commas
+	return bytes.TrimSpace(data), nil
+}
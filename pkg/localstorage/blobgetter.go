@@ -0,0 +1,144 @@
+package localstorage
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/tmeadon/nsgpeek/pkg/azure"
+)
+
+// BlobGetter lists flow log files that have been downloaded from storage, laid out as they are in the
+// flow log container, so that they can be found and read without going to Azure.
+type BlobGetter struct {
+	fsys   fs.FS
+	root   string
+	closer func() error
+}
+
+// NewDirBlobGetter returns a getter for flow logs downloaded to dir.
+func NewDirBlobGetter(dir string) *BlobGetter {
+	return &BlobGetter{
+		fsys:   os.DirFS(dir),
+		root:   dir,
+		closer: func() error { return nil },
+	}
+}
+
+// NewArchiveBlobGetter returns a getter for flow logs exported to a zip, tar or tar.gz archive.  Tar
+// archives are extracted to a temporary directory which is removed by Close.
+func NewArchiveBlobGetter(archivePath string) (*BlobGetter, error) {
+	name := strings.ToLower(archivePath)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		r, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip archive %v: %w", archivePath, err)
+		}
+		return &BlobGetter{fsys: r, root: archivePath, closer: r.Close}, nil
+
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"), strings.HasSuffix(name, ".tar"):
+		dir, err := extractTar(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		return &BlobGetter{fsys: os.DirFS(dir), root: archivePath, closer: func() error { return os.RemoveAll(dir) }}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported archive %v, expected a .zip, .tar, .tar.gz or .tgz file", archivePath)
+}
+
+// Root returns the directory or archive the getter reads from.
+func (g *BlobGetter) Root() string {
+	return g.root
+}
+
+// Close releases the archive the getter reads from.
+func (g *BlobGetter) Close() error {
+	return g.closer()
+}
+
+func (g *BlobGetter) ListBlobDirectory(prefix string) (blobs []azure.Blob, prefixes []string, err error) {
+	dir := prefixDir(prefix)
+
+	entries, err := fs.ReadDir(g.fsys, dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list blob directory with prefix %v: %w", prefix, err)
+	}
+
+	for _, e := range entries {
+		p := prefix + e.Name()
+
+		if e.IsDir() {
+			prefixes = append(prefixes, p+"/")
+			continue
+		}
+
+		if !isLogFile(e.Name()) {
+			continue
+		}
+
+		b, err := g.newBlob(p, e)
+		if err != nil {
+			return nil, nil, err
+		}
+		blobs = append(blobs, b)
+	}
+
+	return
+}
+
+func (g *BlobGetter) ListBlobs(prefix string) (blobs []azure.Blob, err error) {
+	err = fs.WalkDir(g.fsys, prefixDir(prefix), func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if e.IsDir() || !isLogFile(e.Name()) {
+			return nil
+		}
+
+		b, err := g.newBlob(p, e)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, b)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs under prefix %v: %w", prefix, err)
+	}
+
+	return
+}
+
+func (g *BlobGetter) newBlob(p string, e fs.DirEntry) (azure.Blob, error) {
+	info, err := e.Info()
+	if err != nil {
+		return azure.Blob{}, fmt.Errorf("failed to get file info for %v: %w", p, err)
+	}
+
+	return azure.Blob{
+		Store:        &fileBlob{fsys: g.fsys, path: p, url: g.root + "/" + p},
+		Path:         p,
+		LastModified: info.ModTime().UTC(),
+		Size:         info.Size(),
+	}, nil
+}
+
+// prefixDir returns the directory a blob prefix ending in a slash refers to.
+func prefixDir(prefix string) string {
+	dir := strings.TrimSuffix(prefix, "/")
+	if dir == "" {
+		return "."
+	}
+	return dir
+}
+
+func isLogFile(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".json")
+}
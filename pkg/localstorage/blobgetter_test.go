@@ -0,0 +1,206 @@
+package localstorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tmeadon/nsgpeek/pkg/flowlog"
+)
+
+const testNsgPrefix = "insights-logs-networksecuritygroupflowevent/resourceId=/SUBSCRIPTIONS/XYZ/RESOURCEGROUPS/NSG-VIEW/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW/"
+
+var testBlobPath = testNsgPrefix + "y=2022/m=08/d=09/h=10/m=00/macAddress=000D3AD488D1/PT1H.json"
+
+func testRecord(tuple string) string {
+	return `{"time":"2022-08-09T10:03:27Z","macAddress":"000D3AD488D1","category":"NetworkSecurityGroupFlowEvent","resourceId":"/SUBSCRIPTIONS/XYZ/RESOURCEGROUPS/NSG-VIEW/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW","operationName":"NetworkSecurityGroupFlowEvents","properties":{"Version":2,"flows":[{"rule":"UserRule_ssh","flows":[{"mac":"000D3AD488D1","flowTuples":["` + tuple + `"]}]}]}}`
+}
+
+// testFlowLog is laid out the way Azure writes PT1H.json files, with a record on each line.
+var testFlowLog = "{\"records\":[" + testRecord("1660039344,10.0.0.4,10.0.0.5,50276,22,T,I,A,B,,,,") + "\r\n," + testRecord("1660039351,10.0.0.6,10.0.0.5,59246,22,T,I,A,B,,,,") + "]}\n"
+
+func writeTestTree(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for p, data := range files {
+		dest := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dest, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// readTuples reads every record block from a blob in the getter in the same way as the blob reader.
+func readTuples(t *testing.T, g *BlobGetter, p string) []flowlog.FlowTuple {
+	blobs, err := g.ListBlobs(strings.TrimSuffix(p, "PT1H.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(blobs) != 1 {
+		t.Fatalf("expected 1 blob, got %v", len(blobs))
+	}
+
+	b := blobs[0]
+	blocks, err := b.GetBlocks(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var tuples []flowlog.FlowTuple
+	index := int64(0)
+
+	for i := range blocks {
+		data, err := b.ReadBlock(context.Background(), &blocks[i], index)
+		if err != nil {
+			t.Fatalf("unexpected error reading block %v: %v", i, err)
+		}
+		index += blocks[i].Size
+
+		if i == 0 || i == len(blocks)-1 {
+			continue
+		}
+
+		fb, err := flowlog.ParseBlock(data)
+		if err != nil {
+			t.Fatalf("failed to parse block %v %q: %v", i, data, err)
+		}
+		tuples = append(tuples, fb.Tuples()...)
+	}
+
+	return tuples
+}
+
+func TestBlobGetter(t *testing.T) {
+	t.Run("ListsDirectoriesAsPrefixes", func(t *testing.T) {
+		dir := writeTestTree(t, map[string]string{testBlobPath: testFlowLog, "notes.txt": "ignored"})
+		g := NewDirBlobGetter(dir)
+
+		blobs, prefixes, err := g.ListBlobDirectory("")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want := []string{"insights-logs-networksecuritygroupflowevent/"}; !reflect.DeepEqual(prefixes, want) || len(blobs) != 0 {
+			t.Errorf("unexpected listing. want prefixes %v and no blobs, got %v and %v", want, prefixes, blobs)
+		}
+	})
+
+	t.Run("ListsBlobsUnderPrefix", func(t *testing.T) {
+		other := testNsgPrefix + "y=2022/m=08/d=09/h=11/m=00/macAddress=000D3AD488D1/PT1H.json"
+		dir := writeTestTree(t, map[string]string{testBlobPath: testFlowLog, other: testFlowLog})
+		g := NewDirBlobGetter(dir)
+
+		blobs, err := g.ListBlobs(testNsgPrefix + "y=2022/m=08/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var got []string
+		for _, b := range blobs {
+			got = append(got, b.Path)
+			if b.Size != int64(len(testFlowLog)) {
+				t.Errorf("unexpected size for blob %v: %v", b.Path, b.Size)
+			}
+		}
+		sort.Strings(got)
+
+		if want := []string{testBlobPath, other}; !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected blobs. want: %v, got: %v", want, got)
+		}
+	})
+
+	t.Run("ReadsEachRecordAsABlock", func(t *testing.T) {
+		g := NewDirBlobGetter(writeTestTree(t, map[string]string{testBlobPath: testFlowLog}))
+
+		tuples := readTuples(t, g, testBlobPath)
+
+		if len(tuples) != 2 || !tuples[1].Time.Equal(time.Unix(1660039351, 0)) {
+			t.Errorf("unexpected tuples: %+v", tuples)
+		}
+	})
+
+	t.Run("ReturnsErrorForFileThatIsNotAFlowLog", func(t *testing.T) {
+		g := NewDirBlobGetter(writeTestTree(t, map[string]string{testBlobPath: `{"value":[]}`}))
+
+		blobs, err := g.ListBlobs(testNsgPrefix)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := blobs[0].GetBlocks(context.Background()); err == nil {
+			t.Error("expected an error for a file without records")
+		}
+	})
+
+	t.Run("ReadsZipArchives", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "logs.zip")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zw := zip.NewWriter(f)
+		w, err := zw.Create(testBlobPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(testFlowLog))
+		zw.Close()
+		f.Close()
+
+		g, err := NewArchiveBlobGetter(archive)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer g.Close()
+
+		if tuples := readTuples(t, g, testBlobPath); len(tuples) != 2 {
+			t.Errorf("unexpected number of tuples. want: 2, got: %v", len(tuples))
+		}
+	})
+
+	t.Run("ExtractsTarGzArchives", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "logs.tar.gz")
+		f, err := os.Create(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gw := gzip.NewWriter(f)
+		tw := tar.NewWriter(gw)
+		// entries can't be written outside of the extraction directory
+		tw.WriteHeader(&tar.Header{Name: "../../" + testBlobPath, Mode: 0o644, Size: int64(len(testFlowLog)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(testFlowLog))
+		tw.Close()
+		gw.Close()
+		f.Close()
+
+		g, err := NewArchiveBlobGetter(archive)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if tuples := readTuples(t, g, testBlobPath); len(tuples) != 2 {
+			t.Errorf("unexpected number of tuples. want: 2, got: %v", len(tuples))
+		}
+
+		if err := g.Close(); err != nil {
+			t.Errorf("unexpected error closing archive: %v", err)
+		}
+	})
+
+	t.Run("RejectsUnknownArchiveTypes", func(t *testing.T) {
+		if _, err := NewArchiveBlobGetter("logs.rar"); err == nil {
+			t.Error("expected an error for an unsupported archive")
+		}
+	})
+}
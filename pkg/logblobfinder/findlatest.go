@@ -1,7 +1,11 @@
 package logblobfinder
 
 import (
+	"context"
+	"log"
+	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/tmeadon/nsgpeek/pkg/azure"
@@ -11,55 +15,128 @@ var getBlobUrl = func(blob *azure.Blob) string {
 	return blob.URL()
 }
 
-func (f *Finder) FindLatest(ch chan (*azure.Blob), errCh chan (error), sleepDuration time.Duration) {
-	logPrefix, err := f.findNsgBlobPrefix()
+var macPrefixRegex = regexp.MustCompile(`(?i)/macaddress=[^/]*/$`)
+
+// FindLatest sends the NSG's blobs for the newest hour to ch, one for each NIC, checking again every
+// sleepDuration and sending the blobs again whenever a NIC's blob appears or goes away, until ctx is
+// cancelled.  If the NSG hasn't written any logs yet it waits for them to appear.
+func (f *Finder) FindLatest(ctx context.Context, ch chan ([]*azure.Blob), errCh chan (error), sleepDuration time.Duration) {
+	logPrefix, err := f.waitForNsgBlobPrefix(ctx, sleepDuration)
 	if err != nil {
-		errCh <- err
+		sendErr(ctx, errCh, err)
+		return
+	}
+	if logPrefix == "" {
 		return
 	}
 
-	var currentBlobUrl string
+	var currentBlobUrls string
 
 	for {
-		newestBlob, err := f.findNewestBlob(logPrefix)
+		latestBlobs, err := f.findLatestBlobs(logPrefix)
 
 		if err != nil {
-			errCh <- err
+			sendErr(ctx, errCh, err)
 			return
 		}
 
-		blobUrl := getBlobUrl(newestBlob)
+		if blobUrls := joinBlobUrls(latestBlobs); len(latestBlobs) > 0 && currentBlobUrls != blobUrls {
+			select {
+			case ch <- latestBlobs:
+			case <-ctx.Done():
+				return
+			}
+			currentBlobUrls = blobUrls
+		}
 
-		if currentBlobUrl != blobUrl {
-			ch <- newestBlob
-			currentBlobUrl = blobUrl
+		select {
+		case <-time.After(sleepDuration):
+		case <-ctx.Done():
+			return
 		}
+	}
+}
 
-		time.Sleep(sleepDuration)
+// waitForNsgBlobPrefix returns the prefix of the NSG's blobs, checking every sleepDuration until it
+// exists.  An empty prefix is returned if ctx is cancelled first.
+func (f *Finder) waitForNsgBlobPrefix(ctx context.Context, sleepDuration time.Duration) (string, error) {
+	for {
+		logPrefix, err := f.findNsgBlobPrefix()
+		if err != nil || logPrefix != "" {
+			return logPrefix, err
+		}
+
+		log.Printf("no logs found for nsg %v yet", f.nsgName)
+
+		select {
+		case <-time.After(sleepDuration):
+		case <-ctx.Done():
+			return "", nil
+		}
 	}
 }
 
-func (f *Finder) findNewestBlob(prefix string) (*azure.Blob, error) {
+func sendErr(ctx context.Context, errCh chan (error), err error) {
+	select {
+	case errCh <- err:
+	case <-ctx.Done():
+	}
+}
+
+// findLatestBlobs descends into the newest prefix at each level below prefix until it reaches the
+// hour, where each NIC writes to its own macAddress= prefix, and returns the newest blob under each.
+func (f *Finder) findLatestBlobs(prefix string) ([]*azure.Blob, error) {
 	blobs, childPrefixes, err := f.ListBlobDirectory(prefix)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(childPrefixes) == 0 {
-		var newestBlob azure.Blob
+		if b := newestBlob(blobs); b != nil {
+			return []*azure.Blob{b}, nil
+		}
+		return nil, nil
+	}
 
-		for _, b := range blobs {
-			if b.LastModified.After(newestBlob.LastModified) {
-				newestBlob = b
-			}
+	if !macPrefixRegex.MatchString(childPrefixes[0]) {
+		return f.findLatestBlobs(getNewestPrefix(childPrefixes))
+	}
+
+	sort.Strings(childPrefixes)
+	var latest []*azure.Blob
+
+	for _, p := range childPrefixes {
+		macBlobs, err := f.ListBlobs(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if b := newestBlob(macBlobs); b != nil {
+			latest = append(latest, b)
 		}
+	}
+
+	return latest, nil
+}
+
+func newestBlob(blobs []azure.Blob) *azure.Blob {
+	var newest *azure.Blob
 
-		return &newestBlob, nil
+	for i := range blobs {
+		if newest == nil || blobs[i].LastModified.After(newest.LastModified) {
+			newest = &blobs[i]
+		}
 	}
 
-	newestPrefix := getNewestPrefix(childPrefixes)
-	newestBlob, err := f.findNewestBlob(newestPrefix)
-	return newestBlob, err
+	return newest
+}
+
+func joinBlobUrls(blobs []*azure.Blob) string {
+	urls := make([]string, 0, len(blobs))
+	for _, b := range blobs {
+		urls = append(urls, getBlobUrl(b))
+	}
+	return strings.Join(urls, "\n")
 }
 
 func getNewestPrefix(prefixes []string) string {
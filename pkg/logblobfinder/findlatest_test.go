@@ -1,7 +1,9 @@
 package logblobfinder
 
 import (
+	"context"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -16,7 +18,7 @@ func TestFindLatest(t *testing.T) {
 	fakeBlobUrl := "https://path.to/blob"
 	fakeBlobs := []*azure.Blob{{Path: "0"}, {Path: "1"}, {Path: "2"}}
 
-	var blobCh chan *azure.Blob
+	var blobCh chan []*azure.Blob
 	var errCh chan error
 	var prefixCh chan string
 	var mockStorageBlobGetter *nsgpeektest.StorageBlobGetter
@@ -29,7 +31,7 @@ func TestFindLatest(t *testing.T) {
 	}
 
 	setup := func() {
-		blobCh = make(chan (*azure.Blob), 5)
+		blobCh = make(chan ([]*azure.Blob), 5)
 		errCh = make(chan (error))
 		prefixCh = make(chan (string))
 		mockStorageBlobGetter = nsgpeektest.NewFakeStorageBlobGetter(fakeBlobs, []azure.Blob{
@@ -62,30 +64,45 @@ func TestFindLatest(t *testing.T) {
 				LastModified: time.Date(2022, 5, 1, 1, 0, 0, 0, time.UTC),
 			},
 		}, prefixCh, true)
-		finder = Finder{mockStorageBlobGetter, fakeNsgName}
+		finder = Finder{storageBlobGetter: mockStorageBlobGetter, nsgName: fakeNsgName}
 		overrideGetBlobUrl(fakeBlobUrl)
 	}
 
-	waitForBlob := func(t *testing.T, expectedBlob *azure.Blob, timeout time.Duration) {
-		select {
-		case <-prefixCh:
+	latestBlobPath := func(mac string) string {
+		return fmt.Sprintf("/subscriptions/xxxx/resourceGroups/xxxx/providers/microsoft.network/NETWORKSECURITYGROUPS/%v/y=2022/m=05/d=01/h=12/m=00/macAddress=%v/PT1H.json", fakeNsgName, mac)
+	}
 
-		case blob := <-blobCh:
-			if blob != expectedBlob {
-				t.Errorf("wrong blob received from goroutine. expected: %v; got: %v", expectedBlob, blob)
-			}
+	waitForBlobs := func(t *testing.T, wantPaths []string, timeout time.Duration) {
+		t.Helper()
+		deadline := time.After(timeout)
+
+		for {
+			select {
+			case <-prefixCh:
+
+			case blobs := <-blobCh:
+				var got []string
+				for _, b := range blobs {
+					got = append(got, b.Path)
+				}
+
+				if !reflect.DeepEqual(got, wantPaths) {
+					t.Errorf("wrong blobs received from goroutine. expected: %v; got: %v", wantPaths, got)
+				}
+				return
 
-		case err := <-errCh:
-			t.Errorf("unexpected error received: %v", err)
+			case err := <-errCh:
+				t.Fatalf("unexpected error received: %v", err)
 
-		case <-time.After(timeout):
-			t.Errorf("timed out waiting for latest blob to be found")
+			case <-deadline:
+				t.Fatalf("timed out waiting for latest blobs to be found")
+			}
 		}
 	}
 
 	t.Run("SearchesForBlobWithCorrectPrefix", func(t *testing.T) {
 		setup()
-		go finder.FindLatest(blobCh, errCh, time.Second*3)
+		go finder.FindLatest(context.Background(), blobCh, errCh, time.Second*3)
 		searchedPrefixes := make([]string, 0)
 
 	wait:
@@ -108,12 +125,29 @@ func TestFindLatest(t *testing.T) {
 
 	t.Run("SendsNewBlob", func(t *testing.T) {
 		setup()
-		go finder.FindLatest(blobCh, errCh, time.Second*2)
+		go finder.FindLatest(context.Background(), blobCh, errCh, time.Second*2)
 
-		waitForBlob(t, fakeBlobs[0], time.Second*5)
+		waitForBlobs(t, []string{latestBlobPath("abc")}, time.Second*5)
 
 		// change the newest blob
 		overrideGetBlobUrl(fakeBlobUrl + "/new")
-		waitForBlob(t, fakeBlobs[1], time.Second*5)
+		waitForBlobs(t, []string{latestBlobPath("abc")}, time.Second*5)
+	})
+
+	t.Run("SendsBlobForEachMac", func(t *testing.T) {
+		setup()
+		overrideGetBlobUrl("")
+		getBlobUrl = func(b *azure.Blob) string { return b.Path }
+		mockStorageBlobGetter.Blobs = append(mockStorageBlobGetter.Blobs, azure.Blob{Path: latestBlobPath("def"), LastModified: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go finder.FindLatest(ctx, blobCh, errCh, time.Millisecond*100)
+
+		waitForBlobs(t, []string{latestBlobPath("abc"), latestBlobPath("def")}, time.Second*5)
+
+		// a nic starts writing logs, the finder is blocked sending the next prefix so the blobs can be changed
+		mockStorageBlobGetter.Blobs = append(mockStorageBlobGetter.Blobs, azure.Blob{Path: latestBlobPath("ghi"), LastModified: time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)})
+		waitForBlobs(t, []string{latestBlobPath("abc"), latestBlobPath("def"), latestBlobPath("ghi")}, time.Second*5)
 	})
 }
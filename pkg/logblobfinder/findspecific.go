@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tmeadon/nsgpeek/pkg/azure"
@@ -12,6 +13,7 @@ import (
 var (
 	blobPathRe     = regexp.MustCompile(`.*(y=\d{4})\/?(m=\d{2})?\/?(d=\d{2})?\/?(h=\d{2})?\/?(m=\d{2})?\/?`)
 	blobPathElemRe = regexp.MustCompile(`[ymdh]=(\d{2,4})`)
+	blobMacRe      = regexp.MustCompile(`(?i)/macaddress=([^/]*)/`)
 )
 
 func (f *Finder) FindSpecific(start time.Time, end time.Time) ([]azure.Blob, error) {
@@ -176,3 +178,27 @@ func timeFromYearMonthDayHour(year int, month int, day int, hour int) time.Time
 func tInRange(start time.Time, end time.Time, t time.Time) bool {
 	return (t.Equal(start) || t.After(start)) && (t.Equal(end) || t.Before(end))
 }
+
+// BlobStartTime returns the start of the hour (UTC) covered by the flow log blob at path.
+func BlobStartTime(path string) (time.Time, error) {
+	elems, err := extractBlobPathElements(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to extract time elements from blob path '%v': %w", path, err)
+	}
+
+	if elems.Year == nil || elems.Month == nil || elems.Day == nil || elems.Hour == nil {
+		return time.Time{}, fmt.Errorf("blob path '%v' does not contain a complete hour", path)
+	}
+
+	return time.Date(*elems.Year, time.Month(*elems.Month), *elems.Day, *elems.Hour, 0, 0, 0, time.UTC), nil
+}
+
+// BlobMac returns the MAC address of the NIC whose flows the blob at path holds, or "" for blobs that
+// aren't split by NIC.
+func BlobMac(path string) string {
+	m := blobMacRe.FindStringSubmatch(path)
+	if m == nil {
+		return ""
+	}
+	return strings.ToUpper(m[1])
+}
@@ -3,6 +3,7 @@ package logblobfinder
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -43,7 +44,7 @@ func TestFindSpecific(t *testing.T) {
 		}
 
 		blobGetter := nsgpeektest.NewFakeStorageBlobGetter([]*azure.Blob{fakeBlob}, append(goodBlobs, badBlobs...), make(chan string), false)
-		finder := Finder{blobGetter, fakeNsgName}
+		finder := Finder{storageBlobGetter: blobGetter, nsgName: fakeNsgName}
 
 		got, err := finder.FindSpecific(start, end)
 		if err != nil {
@@ -55,6 +56,34 @@ func TestFindSpecific(t *testing.T) {
 		}
 	})
 
+	t.Run("MatchesNsgByResourceIdWhenNamesAreDuplicated", func(t *testing.T) {
+		start := time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC)
+		end := time.Date(2022, 01, 02, 0, 0, 0, 0, time.UTC)
+		want := azure.Blob{
+			Path:         "/resourceId=/SUBSCRIPTIONS/XYZ/RESOURCEGROUPS/RG-B/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW/y=2022/m=01/d=01/h=13/m=00/macAddress=0022483F762A/PT1H.json",
+			LastModified: time.Date(2022, 1, 1, 13, 59, 0, 0, time.UTC),
+		}
+		blobs := []azure.Blob{
+			{
+				Path:         "/resourceId=/SUBSCRIPTIONS/XYZ/RESOURCEGROUPS/RG-A/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW/y=2022/m=01/d=01/h=13/m=00/macAddress=0022483F762B/PT1H.json",
+				LastModified: time.Date(2022, 1, 1, 13, 59, 0, 0, time.UTC),
+			},
+			want,
+		}
+
+		blobGetter := nsgpeektest.NewFakeStorageBlobGetter([]*azure.Blob{fakeBlob}, blobs, make(chan string), false)
+		finder := Finder{storageBlobGetter: blobGetter, nsgName: "/subscriptions/xyz/resourceGroups/rg-b/providers/Microsoft.Network/networkSecurityGroups/nsg-view"}
+
+		got, err := finder.FindSpecific(start, end)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !nsgpeektest.BlobSlicesEqual(got, []azure.Blob{want}) {
+			t.Fatalf("incorrect blobs returned, expected: %#v, got: %#v", want, got)
+		}
+	})
+
 	t.Run("ReturnsNothingIfDatesReversed", func(t *testing.T) {
 		end := time.Date(2022, 01, 01, 0, 0, 0, 0, time.UTC)
 		start := time.Date(2022, 01, 02, 0, 0, 0, 0, time.UTC)
@@ -78,7 +107,7 @@ func TestFindSpecific(t *testing.T) {
 		}
 
 		blobGetter := nsgpeektest.NewFakeStorageBlobGetter([]*azure.Blob{fakeBlob}, blobs, make(chan string), false)
-		finder := Finder{blobGetter, fakeNsgName}
+		finder := Finder{storageBlobGetter: blobGetter, nsgName: fakeNsgName}
 
 		got, err := finder.FindSpecific(start, end)
 		if err != nil {
@@ -101,7 +130,7 @@ func TestFindSpecific(t *testing.T) {
 		}
 
 		blobGetter := nsgpeektest.NewFakeStorageBlobGetter([]*azure.Blob{fakeBlob}, blobs, make(chan string), false)
-		finder := Finder{blobGetter, fakeNsgName}
+		finder := Finder{storageBlobGetter: blobGetter, nsgName: fakeNsgName}
 
 		_, err := finder.FindSpecific(start, end)
 
@@ -110,3 +139,59 @@ func TestFindSpecific(t *testing.T) {
 		}
 	})
 }
+
+func TestBlobStartTime(t *testing.T) {
+	t.Run("ReturnsHourFromPath", func(t *testing.T) {
+		got, err := BlobStartTime("/resourceId=/SUBSCRIPTIONS/xyz/RESOURCEGROUPS/NSG-VIEW/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW/y=2022/m=01/d=02/h=13/m=00/macAddress=0022483F762A/PT1H.json")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if want := time.Date(2022, 1, 2, 13, 0, 0, 0, time.UTC); !got.Equal(want) {
+			t.Errorf("unexpected start time. want: %v, got: %v", want, got)
+		}
+	})
+
+	t.Run("ReturnsErrorForIncompletePath", func(t *testing.T) {
+		if _, err := BlobStartTime("/resourceId=/SUBSCRIPTIONS/xyz/y=2022/m=01/d=02/"); err == nil {
+			t.Error("expected error for path without an hour")
+		}
+	})
+}
+
+func TestBlobMac(t *testing.T) {
+	const path = "resourceId=/SUBSCRIPTIONS/xyz/RESOURCEGROUPS/RG/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW/y=2022/m=01/d=02/h=13/m=00/macAddress=0022483f762a/PT1H.json"
+
+	if got := BlobMac(path); got != "0022483F762A" {
+		t.Errorf("unexpected mac. want: 0022483F762A, got: %v", got)
+	}
+
+	if got := BlobMac("resourceId=/NSG/y=2022/m=01/d=02/h=13/m=00/PT1H.json"); got != "" {
+		t.Errorf("expected no mac for a path without one, got: %v", got)
+	}
+}
+
+func TestHasBlob(t *testing.T) {
+	const nsgId = "/subscriptions/xyz/resourceGroups/rg/providers/Microsoft.Network/networkSecurityGroups/nsg-view"
+	const path = "resourceId=/SUBSCRIPTIONS/XYZ/RESOURCEGROUPS/RG/PROVIDERS/MICROSOFT.NETWORK/NETWORKSECURITYGROUPS/NSG-VIEW/y=2022/m=01/d=02/h=13/m=00/macAddress=0022483F762A/PT1H.json"
+
+	tests := []struct {
+		name string
+		nsg  string
+		want bool
+	}{
+		{"ResourceId", nsgId, true},
+		{"Name", "nsg-view", true},
+		{"OtherNsg", "nsg-other", false},
+		{"OtherResourceGroup", strings.Replace(nsgId, "/rg/", "/rg2/", 1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Finder{nsgName: tt.nsg}
+			if got := f.HasBlob(path); got != tt.want {
+				t.Errorf("unexpected result. want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
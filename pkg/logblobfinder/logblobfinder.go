@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"regexp"
+	"strings"
 
 	"github.com/tmeadon/nsgpeek/pkg/azure"
+	"github.com/tmeadon/nsgpeek/pkg/localstorage"
 )
 
 var (
@@ -20,25 +23,119 @@ type storageBlobGetter interface {
 
 type Finder struct {
 	storageBlobGetter
+	// nsgName is the name or full resource ID of the NSG whose logs are being found
 	nsgName string
+	// FlowLog is the flow log whose storage account the finder searches
+	FlowLog azure.FlowLog
 }
 
-func NewLogBlobFinder(allSubscriptionIds []string, nsgName string, ctx context.Context, cred *azure.Credential) (*Finder, error) {
-	nsgGetter := azure.NewAzureNsgGetter(nsgName, ctx, cred)
-	stgId, err := nsgGetter.GetNsgFlowLogStorageId(allSubscriptionIds)
+// NewLogBlobFinders finds the flow log storage for an NSG given by name, glob or full resource ID and
+// returns a finder for each NSG and storage account.  Names are looked up in resourceGroup when it is
+// set, otherwise in every subscription given.  Every enabled flow log is used unless flowLogName selects
+// one, and storage accounts are accessed as set out in storageOpts.  NSGs matched by a glob that don't
+// have the flow logs asked for are skipped.
+func NewLogBlobFinders(subscriptionIds []string, resourceGroup string, nsgName string, flowLogName string, storageOpts azure.StorageOptions, ctx context.Context, cred *azure.Credential) ([]*Finder, error) {
+	nsgGetter := azure.NewAzureNsgGetter(nsgName, resourceGroup, ctx, cred)
+
+	if !azure.IsNsgPattern(nsgName) {
+		nsgId, err := nsgGetter.FindNsg(subscriptionIds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find nsg: %w", err)
+		}
+
+		return newNsgFinders(ctx, cred, nsgGetter, nsgId, flowLogName, storageOpts)
+	}
+
+	nsgIds, err := nsgGetter.FindNsgs(subscriptionIds)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get nsg log storage id: %w", err)
+		return nil, fmt.Errorf("failed to find nsgs: %w", err)
+	}
+
+	var finders []*Finder
+
+	for _, nsgId := range nsgIds {
+		f, err := newNsgFinders(ctx, cred, nsgGetter, nsgId, flowLogName, storageOpts)
+		if errors.Is(err, azure.ErrFlowLogsNotEnabled) || errors.Is(err, azure.ErrFlowLogNotFound) {
+			log.Printf("skipping nsg %v: %v", nsgId.Name, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		finders = append(finders, f...)
 	}
 
-	blobGetter, err := azure.NewAzureStorageBlobGetter(ctx, cred, stgId)
+	if len(finders) == 0 {
+		return nil, fmt.Errorf("none of the nsgs matching '%v' have flow logs to read: %w", nsgName, azure.ErrFlowLogsNotEnabled)
+	}
+
+	return finders, nil
+}
+
+// newNsgFinders returns a finder for each storage account the NSG's chosen flow logs write to.
+func newNsgFinders(ctx context.Context, cred *azure.Credential, nsgGetter *azure.AzureNsgGetter, nsgId *azure.ResourceId, flowLogName string, storageOpts azure.StorageOptions) ([]*Finder, error) {
+	flowLogs, err := nsgGetter.GetNsgFlowLogs(nsgId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create blob getter: %w", err)
+		return nil, fmt.Errorf("failed to get nsg flow logs: %w", err)
 	}
 
+	flowLogs, err = azure.SelectFlowLogs(flowLogs, flowLogName)
+	if err != nil {
+		return nil, err
+	}
+
+	var finders []*Finder
+	seen := make(map[string]bool)
+
+	for _, fl := range flowLogs {
+		// flow logs writing to the same account share blobs
+		key := strings.ToLower(fl.StorageId.String())
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		blobGetter, err := azure.NewAzureStorageBlobGetter(ctx, cred, fl.StorageId, storageOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create blob getter for flow log %v: %w", fl.Name, err)
+		}
+
+		finders = append(finders, NewStorageLogBlobFinder(blobGetter, nsgId.String(), fl))
+	}
+
+	return finders, nil
+}
+
+// NewStorageLogBlobFinder returns a finder for the logs of the NSG with the given resource ID written to
+// a storage account by fl.
+func NewStorageLogBlobFinder(getter *azure.AzureStorageBlobGetter, nsgId string, fl azure.FlowLog) *Finder {
+	return &Finder{
+		storageBlobGetter: getter,
+		nsgName:           nsgId,
+		FlowLog:           fl,
+	}
+}
+
+// NewLocalLogBlobFinder returns a finder for the NSG's flow logs in a local copy of a flow log container.
+// The NSG is matched by its path in the copy, so no Azure credentials are needed.
+func NewLocalLogBlobFinder(getter *localstorage.BlobGetter, nsgName string) *Finder {
 	return &Finder{
-		storageBlobGetter: blobGetter,
+		storageBlobGetter: getter,
 		nsgName:           nsgName,
-	}, nil
+		FlowLog:           azure.FlowLog{Name: getter.Root()},
+	}
+}
+
+// Nsg returns the name or full resource ID of the NSG whose logs the finder finds.
+func (f *Finder) Nsg() string {
+	return f.nsgName
+}
+
+// HasBlob reports whether the blob at path, relative to the container, is one of the NSG's logs.
+func (f *Finder) HasBlob(path string) bool {
+	i := strings.Index(path, "/y=")
+	return i >= 0 && isMatch(path[:i+1], f.nsgName)
 }
 
 func (f *Finder) findNsgBlobPrefix() (string, error) {
@@ -56,7 +153,8 @@ func (f *Finder) findBlobPrefix(prefix string) (string, error) {
 		if isMatch(p, f.nsgName) {
 			return p, nil
 		} else if isDifferentNsgPrefix(p, f.nsgName) {
-			return "", nil
+			// siblings may still include the nsg we're looking for, but there's no need to look inside this one
+			continue
 		} else {
 			found, err := f.findBlobPrefix(p)
 
@@ -74,7 +172,12 @@ func (f *Finder) findBlobPrefix(prefix string) (string, error) {
 }
 
 func isMatch(path string, nsgName string) bool {
-	r := regexp.MustCompile(`(?i).*\/networksecuritygroups\/` + nsgName + `\/$`)
+	if azure.IsNsgResourceId(nsgName) {
+		// blob paths contain the upper cased resource id
+		return strings.HasSuffix(strings.ToLower(path), strings.ToLower(strings.TrimSuffix(nsgName, "/"))+"/")
+	}
+
+	r := regexp.MustCompile(`(?i).*\/networksecuritygroups\/` + regexp.QuoteMeta(nsgName) + `\/$`)
 	m := r.Match([]byte(path))
 	return m
 }
@@ -82,7 +185,7 @@ func isMatch(path string, nsgName string) bool {
 func isDifferentNsgPrefix(path string, nsgName string) bool {
 	r := regexp.MustCompile(`(?i).*\/networksecuritygroups\/([^\/]*)\/$`)
 	m := r.FindStringSubmatch(path)
-	if len(m) > 1 && m[1] != nsgName {
+	if len(m) > 1 && !strings.EqualFold(m[1], azure.NsgName(nsgName)) {
 		return true
 	}
 	return false